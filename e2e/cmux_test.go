@@ -30,11 +30,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/version"
 	clientv2 "go.etcd.io/etcd/client/v2"
 	"go.etcd.io/etcd/server/v3/etcdserver/api/etcdhttp"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestConnectionMultiplexing(t *testing.T) {
@@ -149,6 +149,43 @@ func testConnectionMultiplexing(ctx context.Context, t *testing.T, member e2e.Et
 	})
 }
 
+// TestSeparateHTTPListener ensures a member serving HTTP on its own listener
+// keeps HTTP off the gRPC listener, and that gRPC keeps working once the HTTP
+// listener is disabled.
+func TestSeparateHTTPListener(t *testing.T) {
+	e2e.BeforeTest(t)
+	ctx := context.Background()
+	cfg := e2e.EtcdProcessClusterConfig{ClusterSize: 1, ClientHttpSeparate: true}
+	clus, err := e2e.NewEtcdProcessCluster(t, &cfg)
+	require.NoError(t, err)
+	defer clus.Close()
+
+	member := clus.Procs[0]
+	httpEndpoint := member.Config().ClientHttpUrl
+	grpcEndpoint := member.EndpointsGRPC()[0]
+	require.NotEqual(t, grpcEndpoint, httpEndpoint)
+	require.Equal(t, []string{httpEndpoint}, member.EndpointsHTTP())
+
+	c := newClient(t, []string{grpcEndpoint}, e2e.ClientNonTLS, false)
+	_, err = c.Put(ctx, "a", "1")
+	require.NoError(t, err)
+	assert.NoError(t, fetchGrpcGateway(httpEndpoint, "", e2e.ClientNonTLS))
+	assert.NoError(t, fetchMetrics(t, httpEndpoint, "", e2e.ClientNonTLS))
+	assert.Error(t, fetchGrpcGateway(grpcEndpoint, "", e2e.ClientNonTLS), "gRPC listener served HTTP")
+
+	require.NoError(t, member.Stop())
+	mcfg := member.Config()
+	// an empty value, as by default, disables the separate HTTP listener
+	mcfg.Args = e2e.PatchArgs(mcfg.Args, "listen-client-http-urls", "")
+	mcfg.ClientHttpUrl = ""
+	require.NoError(t, member.Start())
+
+	c = newClient(t, []string{grpcEndpoint}, e2e.ClientNonTLS, false)
+	_, err = c.Get(ctx, "a")
+	assert.NoError(t, err)
+	assert.Error(t, fetchGrpcGateway(httpEndpoint, "", e2e.ClientNonTLS), "disabled HTTP listener still served")
+}
+
 func fetchGrpcGateway(endpoint string, httpVersion string, connType e2e.ClientConnType) error {
 	rangeData, err := json.Marshal(&pb.RangeRequest{
 		Key: []byte("a"),
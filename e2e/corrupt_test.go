@@ -19,18 +19,21 @@ package e2e
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/server/v3/datadir"
 	"go.etcd.io/etcd/server/v3/storage/mvcc/testutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestEtcdCorruptHash(t *testing.T) {
@@ -101,6 +104,58 @@ func corruptTest(cx ctlCtx) {
 	e2e.WaitReadyExpectProc(proc, []string{fmt.Sprintf("etcdmain: %016x found data inconsistency with peers", id0)})
 }
 
+func TestEtcdCorruptHashKVCompare(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	// trigger snapshot so that restart member can load peers from disk
+	cfg.SnapshotCount = 3
+
+	testCtl(t, corruptHashKVCompareTest, withQuorum(),
+		withCfg(*cfg),
+		withCorruptFunc(testutil.CorruptBBolt),
+	)
+}
+
+// corruptHashKVCompareTest ensures compareHashKV finds the members consistent
+// until the backend of one of them is corrupted offline.
+func corruptHashKVCompareTest(cx ctlCtx) {
+	for i := 0; i < 10; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("foo%05d", i), fmt.Sprintf("v%05d", i), ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	consistent, hashes, err := compareHashKV(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if !consistent {
+		cx.t.Fatalf("expected the members to be consistent, got hashes %v", hashes)
+	}
+
+	proc := cx.epc.Procs[0]
+	if err = proc.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = cx.corruptFunc(datadir.ToBackendFileName(proc.Config().DataDirPath)); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = proc.Start(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if consistent, hashes, err = compareHashKV(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	if consistent {
+		cx.t.Fatalf("expected the corrupted member to be detected, got hashes %v", hashes)
+	}
+	corrupted := hashes[proc.EndpointsV3()[0]]
+	for _, other := range cx.epc.Procs[1:] {
+		if hashes[other.EndpointsV3()[0]] == corrupted {
+			cx.t.Fatalf("expected %s to hash differently from the corrupted member, got hashes %v", other.Config().Name, hashes)
+		}
+	}
+}
+
 func TestInPlaceRecovery(t *testing.T) {
 	basePort := 20000
 	e2e.BeforeTest(t)
@@ -234,6 +289,150 @@ func TestPeriodicCheckDetectsCorruption(t *testing.T) {
 	assert.Equal(t, []*etcdserverpb.AlarmMember{{Alarm: etcdserverpb.AlarmType_CORRUPT, MemberID: 0}}, alarmResponse.Alarms)
 }
 
+// TestEtcdRecoverTruncatedWALTail ensures a member whose newest WAL record was
+// only partially written before a crash repairs the WAL on restart, replaying
+// every intact record.
+func TestEtcdRecoverTruncatedWALTail(t *testing.T) {
+	e2e.BeforeTest(t)
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 1,
+		KeepDataDir: true,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	t.Cleanup(func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	})
+
+	cc := e2e.NewEtcdctl(epc.EndpointsV3(), e2e.ClientNonTLS, false, false)
+	const keys = 10
+	for i := 0; i < keys; i++ {
+		err := cc.Put(fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+		require.NoError(t, err, "error on put")
+	}
+
+	require.NoError(t, epc.Procs[0].Stop())
+	// a few bytes only tear the last record, which is never larger than this
+	require.NoError(t, e2e.TruncateWALTail(epc.Procs[0].Config().DataDirPath, 4))
+	require.NoError(t, epc.Procs[0].Restart())
+
+	// only the torn record may be lost, which at most holds the last put
+	for i := 0; i < keys-1; i++ {
+		resp, err := cc.Get(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err, "error on get")
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, fmt.Sprint(i), string(resp.Kvs[0].Value))
+	}
+	require.NoError(t, cc.Put("foo", "bar"), "member should accept writes after repairing its WAL")
+}
+
+// TestEtcdRecoverCorruptedSnapshot ensures a member whose snapshot files are
+// all corrupted detects it on restart, setting the files aside rather than
+// loading them, and recovers its state from the WAL alone.
+func TestEtcdRecoverCorruptedSnapshot(t *testing.T) {
+	e2e.BeforeTest(t)
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:   1,
+		KeepDataDir:   true,
+		SnapshotCount: 5,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	t.Cleanup(func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	})
+
+	cc := e2e.NewEtcdctl(epc.EndpointsV3(), e2e.ClientNonTLS, false, false)
+	const keys = 20
+	for i := 0; i < keys; i++ {
+		err := cc.Put(fmt.Sprintf("key-%d", i), fmt.Sprint(i))
+		require.NoError(t, err, "error on put")
+	}
+
+	dataDir := epc.Procs[0].Config().DataDirPath
+	require.NoError(t, epc.Procs[0].Stop())
+	snaps, err := e2e.SnapshotFiles(dataDir)
+	require.NoError(t, err)
+	require.NoError(t, e2e.CorruptSnapshotFiles(dataDir))
+	require.NoError(t, epc.Procs[0].Restart())
+
+	for _, snap := range snaps {
+		assert.FileExists(t, snap+".broken", "corrupted snapshot should be set aside")
+	}
+	for i := 0; i < keys; i++ {
+		resp, err := cc.Get(fmt.Sprintf("key-%d", i))
+		require.NoError(t, err, "error on get")
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, fmt.Sprint(i), string(resp.Kvs[0].Value))
+	}
+	require.NoError(t, cc.Put("foo", "bar"), "member should accept writes after recovering from its WAL")
+}
+
+// TestEtcdDiskFull ensures a member running out of disk space stops without
+// corrupting the data it persisted, and catches up once space is freed.
+func TestEtcdDiskFull(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:         3,
+		InitialCorruptCheck: true,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	t.Cleanup(func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	})
+
+	member := epc.Procs[0]
+	err = epc.SimulateDiskFull(0)
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("cannot mount a tmpfs data dir (%v)", err)
+	}
+	require.NoError(t, err)
+
+	// write through the healthy members until the full one gives up
+	var eps []string
+	for _, p := range epc.Procs[1:] {
+		eps = append(eps, p.EndpointsV3()...)
+	}
+	cli := newClient(t, eps, epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	val := strings.Repeat("a", 1024*1024)
+	keys := 0
+	deadline := time.Now().Add(time.Minute)
+	for member.IsRunning() {
+		require.True(t, time.Now().Before(deadline), "expected member to stop once its disk is full")
+		// puts fail while a new leader is elected if the full member led
+		if _, err = cli.Put(context.TODO(), fmt.Sprintf("key-%d", keys), val); err != nil {
+			t.Logf("put failed (%v)", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		keys++
+	}
+	t.Logf("member stopped after %d puts", keys)
+
+	require.NoError(t, epc.FreeDiskSpace(0))
+	// the initial corrupt check fails the restart if the persisted data diverged
+	require.NoError(t, member.Restart())
+
+	mcli := newClient(t, member.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	for i := 0; i < keys; i++ {
+		resp, err := mcli.Get(context.TODO(), fmt.Sprintf("key-%d", i))
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, 1)
+		assert.Equal(t, val, string(resp.Kvs[0].Value))
+	}
+}
+
 func TestCompactHashCheckDetectCorruption(t *testing.T) {
 	checkTime := time.Second
 	e2e.BeforeTest(t)
@@ -22,7 +22,7 @@ import (
 	"testing"
 	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func BeforeTestV2(t testing.TB) {
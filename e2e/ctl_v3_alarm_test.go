@@ -16,13 +16,15 @@ package e2e
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3Alarm(t *testing.T) {
@@ -30,6 +32,14 @@ func TestCtlV3Alarm(t *testing.T) {
 	testCtl(t, alarmTest, withQuota(int64(13*os.Getpagesize())))
 }
 
+func TestCtlV3AlarmListDisarm(t *testing.T) {
+	testCtl(t, alarmListDisarmTest, withQuota(int64(13*os.Getpagesize())))
+}
+
+func TestCtlV3AlarmRaiseQuota(t *testing.T) {
+	testCtl(t, alarmRaiseQuotaTest, withQuota(int64(13*os.Getpagesize())))
+}
+
 func alarmTest(cx ctlCtx) {
 	// test small put still works
 	smallbuf := strings.Repeat("a", 64)
@@ -104,3 +114,110 @@ func ctlV3Alarm(cx ctlCtx, cmd string, as ...string) error {
 	cmdArgs := append(cx.PrefixArgs(), "alarm", cmd)
 	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, as...)
 }
+
+// alarmRaiseQuotaTest fills the quota, then restarts the cluster with a larger
+// one. The NOSPACE alarm survives the restart, but once disarmed writes resume
+// on the existing data without any compaction or defrag.
+func alarmRaiseQuotaTest(cx ctlCtx) {
+	buf := strings.Repeat("b", os.Getpagesize())
+	for i := 0; ; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("overfill-%d", i), buf, ""); err != nil {
+			if !strings.Contains(err.Error(), "etcdserver: mvcc: database space exceeded") {
+				cx.t.Fatal(err)
+			}
+			break
+		}
+	}
+
+	if err := cx.restartWithQuota(int64(256 * os.Getpagesize())); err != nil {
+		cx.t.Fatal(err)
+	}
+	alarms, err := listAlarms(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(alarms) != 1 || alarms[0].Type != pb.AlarmType_NOSPACE {
+		cx.t.Fatalf("expected the NOSPACE alarm to survive the restart, got %+v", alarms)
+	}
+	if err = disarmAlarms(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err = ctlV3Put(cx, fmt.Sprintf("resumed-%d", i), buf, ""); err != nil {
+			cx.t.Fatalf("expected writes to resume under the larger quota (%v)", err)
+		}
+	}
+	if alarms, err = listAlarms(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(alarms) != 0 {
+		cx.t.Fatalf("expected no alarms under the larger quota, got %+v", alarms)
+	}
+}
+
+// Alarm is an alarm raised on a member.
+type Alarm struct {
+	MemberID uint64
+	Type     pb.AlarmType
+}
+
+// listAlarms returns the alarms raised in the cluster.
+func listAlarms(cx ctlCtx) ([]Alarm, error) {
+	resp, err := runJSONCmd[pb.AlarmResponse](cx, "alarm", "list")
+	if err != nil {
+		return nil, err
+	}
+	var alarms []Alarm
+	for _, a := range resp.Alarms {
+		alarms = append(alarms, Alarm{MemberID: a.MemberID, Type: a.Alarm})
+	}
+	return alarms, nil
+}
+
+// disarmAlarms disarms every alarm raised in the cluster.
+func disarmAlarms(cx ctlCtx) error {
+	_, err := e2e.RunUtilCompletion(append(cx.PrefixArgs(), "alarm", "disarm"), cx.envMap)
+	return err
+}
+
+// alarmListDisarmTest overfills the quota and ensures the NOSPACE alarm is
+// listed, with the member that raised it, until it is disarmed.
+func alarmListDisarmTest(cx ctlCtx) {
+	alarms, err := listAlarms(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(alarms) != 0 {
+		cx.t.Fatalf("expected no alarms, got %+v", alarms)
+	}
+
+	buf := strings.Repeat("b", os.Getpagesize())
+	for {
+		if err = ctlV3Put(cx, "overfill", buf, ""); err != nil {
+			if !strings.Contains(err.Error(), "etcdserver: mvcc: database space exceeded") {
+				cx.t.Fatal(err)
+			}
+			break
+		}
+	}
+
+	alarms, err = listAlarms(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	memberID := memberIDByPeerURL(cx, cx.epc.EndpointsV3()[0], cx.epc.Procs[0].Config().Purl.String(), false)
+	if len(alarms) != 1 || alarms[0] != (Alarm{MemberID: memberID, Type: pb.AlarmType_NOSPACE}) {
+		cx.t.Fatalf("expected a NOSPACE alarm raised by %x, got %+v", memberID, alarms)
+	}
+
+	if err = disarmAlarms(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	alarms, err = listAlarms(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(alarms) != 0 {
+		cx.t.Fatalf("expected no alarms after disarming, got %+v", alarms)
+	}
+}
@@ -25,7 +25,7 @@ import (
 	"testing"
 	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestCtlV3AuthCertCN(t *testing.T) {
@@ -37,6 +37,12 @@ func TestCtlV3AuthCertCNAndUsername(t *testing.T) {
 func TestCtlV3AuthCertCNAndUsernameNoPassword(t *testing.T) {
 	testCtl(t, authTestCertCNAndUsernameNoPassword, withCfg(*e2e.NewConfigClientTLSCertAuth()))
 }
+func TestCtlV3AuthClientCertCN(t *testing.T) {
+	testCtl(t, authTestClientCertCN, withClientCertCN("cn-user"))
+}
+func TestCtlV3AuthNoPasswordUser(t *testing.T) {
+	testCtl(t, authTestNoPasswordUser, withClientCertCN("cn-user"))
+}
 
 func TestCtlV3AuthCertCNWithWithConcurrentOperation(t *testing.T) {
 	e2e.BeforeTest(t)
@@ -20,19 +20,25 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"google.golang.org/grpc/metadata"
 )
 
 func TestCtlV3AuthEnable(t *testing.T) {
 	testCtl(t, authEnableTest)
 }
+func TestCtlV3AuthWithAuthOption(t *testing.T) {
+	testCtl(t, authWithAuthOptionTest, withAuth("admin", "pass"))
+}
 func TestCtlV3AuthDisable(t *testing.T)             { testCtl(t, authDisableTest) }
 func TestCtlV3AuthGracefulDisable(t *testing.T)     { testCtl(t, authGracefulDisableTest) }
 func TestCtlV3AuthStatus(t *testing.T)              { testCtl(t, authStatusTest) }
@@ -76,17 +82,43 @@ func TestCtlV3AuthSnapshot(t *testing.T) { testCtl(t, authTestSnapshot) }
 func TestCtlV3AuthSnapshotJWT(t *testing.T) {
 	testCtl(t, authTestSnapshot, withCfg(*e2e.NewConfigJWT()))
 }
+func TestCtlV3AuthJWTKeyRotation(t *testing.T) {
+	keyDir := t.TempDir()
+	cfg := e2e.NewConfigNoTLS()
+	cfg.JWTPubKeyFile = filepath.Join(keyDir, "jwt.pub")
+	cfg.JWTPrivKeyFile = filepath.Join(keyDir, "jwt.key")
+	installJWTKeys(t, cfg, "server.crt", "server.key.insecure")
+	testCtl(t, authTestJWTKeyRotation, withCfg(*cfg))
+}
 func TestCtlV3AuthJWTExpire(t *testing.T) {
 	testCtl(t, authTestJWTExpire, withCfg(*e2e.NewConfigJWT()))
 }
+func TestCtlV3AuthWatchJWTExpire(t *testing.T) {
+	testCtl(t, authTestWatchJWTExpire, withCfg(*e2e.NewConfigJWT()))
+}
 func TestCtlV3AuthRevisionConsistency(t *testing.T) { testCtl(t, authTestRevisionConsistency) }
 func TestCtlV3AuthTestCacheReload(t *testing.T)     { testCtl(t, authTestCacheReload) }
 func TestCtlV3AuthLeaseTimeToLive(t *testing.T)     { testCtl(t, authTestLeaseTimeToLive) }
+func TestCtlV3AuthReadAfterEnable(t *testing.T) {
+	testCtl(t, authTestReadAfterEnable, withQuorum())
+}
 
 func TestCtlV3AuthRecoverFromSnapshot(t *testing.T) {
 	testCtl(t, authTestRecoverSnapshot, withCfg(*e2e.NewConfigNoTLS()), withQuorum(), withSnapshotCount(5))
 }
 
+// authWithAuthOptionTest runs as the user set up by withAuth, whose
+// credentials are accepted while requests without credentials are rejected.
+func authWithAuthOptionTest(cx ctlCtx) {
+	if err := ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	cx.user, cx.pass = "", ""
+	if err := e2e.SpawnWithExpectWithEnv(append(cx.PrefixArgs(), "put", "foo", "baz"), cx.envMap, "user name is empty"); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
 func authEnableTest(cx ctlCtx) {
 	if err := authEnable(cx); err != nil {
 		cx.t.Fatal(err)
@@ -129,6 +161,29 @@ func applyTLSWithRootCommonName() func() {
 	}
 }
 
+// authEnableAs creates user with the root role, along with the root user auth
+// enable requires, enables authentication and runs later commands as user.
+// Users are created first, as auth can't be enabled without the root user.
+func authEnableAs(cx *ctlCtx, user, pass string) error {
+	users := []string{user}
+	if user != "root" {
+		users = append(users, "root")
+	}
+	for _, u := range users {
+		if err := ctlV3User(*cx, []string{"add", u, "--interactive=false"}, fmt.Sprintf("User %s created", u), []string{pass}); err != nil {
+			return fmt.Errorf("failed to create user %s (%v)", u, err)
+		}
+		if err := ctlV3User(*cx, []string{"grant-role", u, "root"}, fmt.Sprintf("Role root is granted to user %s", u), nil); err != nil {
+			return fmt.Errorf("failed to grant user %s root role (%v)", u, err)
+		}
+	}
+	if err := ctlV3AuthEnable(*cx); err != nil {
+		return fmt.Errorf("auth enable failed (%v)", err)
+	}
+	cx.user, cx.pass = user, pass
+	return nil
+}
+
 func ctlV3AuthEnable(cx ctlCtx) error {
 	cmdArgs := append(cx.PrefixArgs(), "auth", "enable")
 	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "Authentication Enabled")
@@ -718,6 +773,65 @@ func authTestCertCN(cx ctlCtx) {
 	}
 }
 
+// authTestClientCertCN ensures requests authenticate as the user named by the client
+// certificate CommonName, and that a certificate for another name is refused.
+func authTestClientCertCN(cx ctlCtx) {
+	if err := ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := ctlV3Get(cx, []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	otherCx := cx
+	otherCx.clientCert, otherCx.clientKey = cx.clientCA.issue(cx.t, "other-user")
+	if err := ctlV3PutFailPerm(otherCx, "foo", "baz"); err != nil {
+		cx.t.Fatalf("expected a certificate for an unknown user to be refused (%v)", err)
+	}
+
+	// without the root role the CommonName user may not write anymore
+	rootCx := cx
+	rootCx.user, rootCx.pass = "root", "pass"
+	expStr := fmt.Sprintf("Role root is revoked from user %s", cx.clientCertCN)
+	if err := ctlV3User(rootCx, []string{"revoke-role", cx.clientCertCN, "root"}, expStr, []string{}); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := ctlV3PutFailPerm(cx, "foo", "baz"); err != nil {
+		cx.t.Fatalf("expected requests to authenticate as %s (%v)", cx.clientCertCN, err)
+	}
+}
+
+// authTestNoPasswordUser ensures a user added without a password can't log
+// in with any password, but authenticates by a client certificate issued for
+// its name.
+func authTestNoPasswordUser(cx ctlCtx) {
+	rootCx := cx
+	rootCx.user, rootCx.pass = "root", "pass"
+	if err := ctlV3UserAddNoPassword(rootCx, "nopass-user"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := ctlV3User(rootCx, []string{"grant-role", "nopass-user", "root"}, "Role root is granted to user nopass-user", nil); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	for _, pass := range []string{"", "pass"} {
+		pwCx := cx
+		pwCx.user, pwCx.pass = "nopass-user", pass
+		if err := ctlV3PutFailAuth(pwCx, "foo", "bar"); err != nil {
+			cx.t.Fatalf("expected a password login of nopass-user with %q to fail (%v)", pass, err)
+		}
+	}
+
+	certCx := cx
+	certCx.clientCert, certCx.clientKey = cx.clientCA.issue(cx.t, "nopass-user")
+	if err := ctlV3Put(certCx, "foo", "bar", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := ctlV3Get(certCx, []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
 func authTestRevokeWithDelete(cx ctlCtx) {
 	if err := authEnable(cx); err != nil {
 		cx.t.Fatal(err)
@@ -1240,6 +1354,119 @@ func authTestJWTExpire(cx ctlCtx) {
 	}
 }
 
+// authTestWatchJWTExpire keeps a watch open past the expiry of the JWT token
+// it was created with. The server only checks the token when a watch is
+// created, so the open watch keeps delivering events, while a watch created
+// with the expired token is either retried by the client with a refreshed
+// token or canceled with an invalid auth token error.
+func authTestWatchJWTExpire(cx ctlCtx) {
+	if err := authEnable(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	cli, err := clientv3.New(clientv3.Config{Endpoints: cx.epc.EndpointsV3(), Username: "root", Password: "root", DialTimeout: 3 * time.Second})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wch := cli.Watch(ctx, "foo", clientv3.WithCreatedNotify())
+	if wresp := <-wch; !wresp.Created {
+		cx.t.Fatalf("expected watch to be created, got %+v", wresp)
+	}
+
+	// wait an expiration of the JWT token, 1 second
+	<-time.After(3 * time.Second)
+
+	// distinct metadata opens the new watch on a stream of its own
+	wch2 := cli.Watch(metadata.AppendToOutgoingContext(ctx, "watch-stream", "2"), "foo", clientv3.WithCreatedNotify())
+	select {
+	case wresp := <-wch2:
+		if wresp.Canceled {
+			if err = wresp.Err(); err == nil || !strings.Contains(err.Error(), "invalid auth token") {
+				cx.t.Fatalf("expected watch created with an expired token to fail on the token, got %v", err)
+			}
+		} else if !wresp.Created {
+			cx.t.Fatalf("expected watch to be created, got %+v", wresp)
+		}
+	case <-time.After(5 * time.Second):
+		cx.t.Fatal("expected watch created with an expired token to be either created or canceled")
+	}
+
+	// the put refreshes the expired token of the client
+	if _, err = cli.Put(context.TODO(), "foo", "bar"); err != nil {
+		cx.t.Fatal(err)
+	}
+	select {
+	case wresp := <-wch:
+		if err = wresp.Err(); err != nil {
+			cx.t.Fatalf("expected the open watch to survive the token expiry, got %v", err)
+		}
+		if len(wresp.Events) != 1 || string(wresp.Events[0].Kv.Value) != "bar" {
+			cx.t.Fatalf("expected a single put of foo=bar, got %+v", wresp.Events)
+		}
+	case <-time.After(5 * time.Second):
+		cx.t.Fatal("expected the open watch to keep delivering events after its token expired")
+	}
+}
+
+// authTestJWTKeyRotation replaces the JWT signing key pair and restarts the
+// member, ensuring tokens signed with the old key are rejected while tokens
+// issued after the rotation are accepted.
+func authTestJWTKeyRotation(cx ctlCtx) {
+	if err := authEnable(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	oldToken := authenticateRoot(cx, cli)
+	if err := getWithToken(cli, oldToken); err != nil {
+		cx.t.Fatalf("token signed with the current key rejected (%v)", err)
+	}
+
+	installJWTKeys(cx.t, cx.epc.Cfg, "server2.crt", "server2.key.insecure")
+	if err := cx.epc.Procs[0].Restart(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if err := getWithToken(cli, oldToken); err != rpctypes.ErrInvalidAuthToken {
+		cx.t.Fatalf("expected %v for a token signed with the rotated-out key, got %v", rpctypes.ErrInvalidAuthToken, err)
+	}
+	newToken := authenticateRoot(cx, cli)
+	if err := getWithToken(cli, newToken); err != nil {
+		cx.t.Fatalf("token signed with the new key rejected (%v)", err)
+	}
+}
+
+// installJWTKeys copies the given fixture key pair to the JWT key files of cfg.
+func installJWTKeys(t *testing.T, cfg *e2e.EtcdProcessClusterConfig, pubFixture, privFixture string) {
+	for dst, src := range map[string]string{cfg.JWTPubKeyFile: pubFixture, cfg.JWTPrivKeyFile: privFixture} {
+		b, err := os.ReadFile(filepath.Join(e2e.FixturesDir, src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(dst, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func authenticateRoot(cx ctlCtx, cli *clientv3.Client) string {
+	resp, err := cli.Authenticate(context.TODO(), "root", "root")
+	if err != nil {
+		cx.t.Fatalf("failed to authenticate root (%v)", err)
+	}
+	return resp.Token
+}
+
+// getWithToken issues a get that carries token as its auth token.
+func getWithToken(cli *clientv3.Client, token string) error {
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), rpctypes.TokenFieldNameGRPC, token)
+	_, err := cli.Get(ctx, "foo")
+	return rpctypes.Error(err)
+}
+
 func authTestRevisionConsistency(cx ctlCtx) {
 	if err := authEnable(cx); err != nil {
 		cx.t.Fatal(err)
@@ -1607,3 +1834,68 @@ func authTestLeaseTimeToLive(cx ctlCtx) {
 		cx.t.Fatal(err)
 	}
 }
+
+// authTestReadAfterEnable ensures that requests with valid credentials sent
+// right after auth enable succeed, i.e. there is no window in which the auth
+// store is enabled but not yet able to authenticate users.
+func authTestReadAfterEnable(cx ctlCtx) {
+	endpoints := cx.epc.EndpointsV3()
+
+	c, err := clientv3.New(clientv3.Config{Endpoints: endpoints, DialTimeout: 3 * time.Second})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, err = c.UserAdd(context.TODO(), "root", "root"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if _, err = c.UserGrantRole(context.TODO(), "root", "root"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if _, err = c.Put(context.TODO(), "foo", "bar"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	// repeat the enable sequence a few times to widen the race window
+	for i := 0; i < 5; i++ {
+		if _, err = c.AuthEnable(context.TODO()); err != nil {
+			cx.t.Fatalf("#%d: auth enable error (%v)", i, err)
+		}
+
+		// authenticate against every member, including the ones that
+		// may not have applied the auth enable entry yet
+		for _, ep := range endpoints {
+			if err = authGetWithRoot(ep, "foo", "bar"); err != nil {
+				cx.t.Fatalf("#%d: %v", i, err)
+			}
+		}
+
+		rc, err := clientv3.New(clientv3.Config{Endpoints: endpoints, Username: "root", Password: "root", DialTimeout: 3 * time.Second})
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		_, err = rc.AuthDisable(context.TODO())
+		rc.Close()
+		if err != nil {
+			cx.t.Fatalf("#%d: auth disable error (%v)", i, err)
+		}
+	}
+}
+
+func authGetWithRoot(endpoint, key, expectedVal string) error {
+	c, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, Username: "root", Password: "root", DialTimeout: 3 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to authenticate against %s (%v)", endpoint, err)
+	}
+	defer c.Close()
+
+	resp, err := c.Get(context.TODO(), key)
+	if err != nil {
+		return fmt.Errorf("get from %s error (%v)", endpoint, err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != expectedVal {
+		return fmt.Errorf("unexpected get response from %s: %v", endpoint, resp.Kvs)
+	}
+	return nil
+}
@@ -15,15 +15,36 @@
 package e2e
 
 import (
+	"context"
+	"fmt"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 )
 
 func TestCtlV3Compact(t *testing.T)         { testCtl(t, compactTest) }
 func TestCtlV3CompactPhysical(t *testing.T) { testCtl(t, compactTest, withCompactPhysical()) }
+func TestCtlV3CompactNoop(t *testing.T)     { testCtl(t, compactNoopTest) }
+func TestCtlV3CompactKeepsLatest(t *testing.T) {
+	testCtl(t, compactKeepsLatestTest, withCompactPhysical())
+}
+func TestCtlV3CompactPhysicalReclaim(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	// spread the compaction over many batches so it takes a while to finish
+	cfg.CompactionBatchLimit = 100
+	testCtl(t, compactPhysicalReclaimTest, withCfg(*cfg))
+}
+
+func TestCtlV3CompactBatchLimit(t *testing.T) {
+	if !etcdSupportsFlag("--experimental-compaction-batch-limit") {
+		t.Skip("--experimental-compaction-batch-limit is not supported by the etcd binary")
+	}
+	testCtl(t, compactBatchLimitTest, withQuorum(), withCompactionBatchLimit(10))
+}
 
 func compactTest(cx ctlCtx) {
 	compactPhysical := cx.compactPhysical
@@ -67,6 +88,157 @@ func compactTest(cx ctlCtx) {
 	}
 }
 
+// compactNoopTest ensures compacting at revision 0, or at the initial revision,
+// succeeds without compacting away any revision written after it.
+func compactNoopTest(cx ctlCtx) {
+	var kvs = []kv{{"key", "val1"}, {"key", "val2"}, {"key", "val3"}}
+	for i := range kvs {
+		if err := ctlV3Put(cx, kvs[i].key, kvs[i].val, ""); err != nil {
+			cx.t.Fatalf("compactNoopTest #%d: ctlV3Put error (%v)", i, err)
+		}
+	}
+
+	if err := ctlV3Compact(cx, 0, cx.compactPhysical); err != nil {
+		cx.t.Fatalf("compactNoopTest: compact at revision 0 error (%v)", err)
+	}
+	if err := ctlV3Compact(cx, 1, cx.compactPhysical); err != nil {
+		cx.t.Fatalf("compactNoopTest: compact at revision 1 error (%v)", err)
+	}
+
+	for i := range kvs {
+		rev := strconv.Itoa(i + 2)
+		if err := ctlV3Get(cx, []string{"key", "--rev", rev}, kvs[i]); err != nil {
+			cx.t.Errorf("compactNoopTest: ctlV3Get at revision %s error (%v)", rev, err)
+		}
+	}
+}
+
+// compactKeepsLatestTest compacts at the current revision and ensures the
+// latest revision of every live key is kept, however old, while deleted keys
+// stay deleted.
+func compactKeepsLatestTest(cx ctlCtx) {
+	// key-0 is only written once, before any other revision
+	want := []kv{{"key-0", "once"}}
+	if err := ctlV3Put(cx, want[0].key, want[0].val, ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	for v := 0; v < 3; v++ {
+		for _, key := range []string{"key-1", "key-2", "key-3", "key-deleted"} {
+			if err := ctlV3Put(cx, key, fmt.Sprintf("val-%d", v), ""); err != nil {
+				cx.t.Fatal(err)
+			}
+		}
+	}
+	for _, key := range []string{"key-1", "key-2", "key-3"} {
+		want = append(want, kv{key, "val-2"})
+	}
+	if err := ctlV3Del(cx, []string{"key-deleted"}, 1); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	resp, err := ctlV3GetJSON(cx, "key", "--prefix")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = ctlV3Compact(cx, resp.Header.Revision, cx.compactPhysical); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if resp, err = ctlV3GetJSON(cx, "key", "--prefix"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Kvs) != len(want) {
+		cx.t.Fatalf("compactKeepsLatestTest: expected %d live keys after compaction, got %+v", len(want), resp.Kvs)
+	}
+	for i := range want {
+		if string(resp.Kvs[i].Key) != want[i].key || string(resp.Kvs[i].Value) != want[i].val {
+			cx.t.Fatalf("compactKeepsLatestTest: expected %q=%q after compaction, got %q=%q", want[i].key, want[i].val, resp.Kvs[i].Key, resp.Kvs[i].Value)
+		}
+	}
+}
+
+// compactPhysicalReclaimTest ensures "compact --physical" only returns once
+// the compacted revisions are removed from the backend, which shows in the
+// db size in use. A logical compaction returns as soon as it is scheduled, so
+// the reclaimed space can only be expected eventually.
+func compactPhysicalReclaimTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	fill := func() int64 {
+		var rev int64
+		val := strings.Repeat("a", 2048)
+		for i := 0; i < 5000; i++ {
+			resp, err := cli.Put(context.TODO(), fmt.Sprintf("key-%d", i%10), val)
+			if err != nil {
+				cx.t.Fatal(err)
+			}
+			rev = resp.Header.Revision
+		}
+		return rev
+	}
+
+	rev := fill()
+	before := dbSizeInUse(cx)
+	if err := ctlV3Compact(cx, rev, true); err != nil {
+		cx.t.Fatal(err)
+	}
+	if after := dbSizeInUse(cx); after >= before/2 {
+		cx.t.Fatalf("expected physical compaction to reclaim space before returning, db size in use %d before, %d after", before, after)
+	}
+
+	rev = fill()
+	before = dbSizeInUse(cx)
+	if err := ctlV3Compact(cx, rev, false); err != nil {
+		cx.t.Fatal(err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for after := dbSizeInUse(cx); after >= before/2; after = dbSizeInUse(cx) {
+		if time.Now().After(deadline) {
+			cx.t.Fatalf("expected logical compaction to eventually reclaim space, db size in use %d before, %d after", before, after)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// compactBatchLimitTest compacts many revisions in small batches and ensures
+// every member stays healthy and only the compacted revisions are gone.
+func compactBatchLimitTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	var rev int64
+	for i := 0; i < 1000; i++ {
+		resp, err := cli.Put(context.TODO(), fmt.Sprintf("key-%d", i%100), fmt.Sprintf("val-%d", i))
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		rev = resp.Header.Revision
+	}
+	if err := ctlV3Compact(cx, rev, true); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := waitClusterHealthy(cx, 10*time.Second); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if _, err := getAtRev(cli, "key-0", rev-1); err != rpctypes.ErrCompacted {
+		cx.t.Fatalf("expected %v below the compacted revision, got %v", rpctypes.ErrCompacted, err)
+	}
+	resp, err := ctlV3GetJSON(cx, "key-", "--prefix", "--count-only")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if resp.Count != 100 {
+		cx.t.Fatalf("expected 100 keys after compaction, got %d", resp.Count)
+	}
+}
+
+// dbSizeInUse returns the db size in use reported by the first member.
+func dbSizeInUse(cx ctlCtx) int64 {
+	resp, err := cx.epc.Procs[0].Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false).Status()
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	return resp[0].DbSizeInUse
+}
+
 func ctlV3Compact(cx ctlCtx, rev int64, physical bool) error {
 	rs := strconv.FormatInt(rev, 10)
 	cmdArgs := append(cx.PrefixArgs(), "compact", rs)
@@ -15,13 +15,67 @@
 package e2e
 
 import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	_ "google.golang.org/grpc/health" // enables client side health checking
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
 )
 
 func TestCtlV3DefragOnline(t *testing.T) { testCtl(t, defragOnlineTest) }
 
+func TestCtlV3DefragLeaderNoLeaderChange(t *testing.T) {
+	testCtl(t, defragLeaderNoLeaderChangeTest, withQuorum())
+}
+
+func TestCtlV3DefragReclaimSpace(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.BasePeerScheme = "unix"
+	testCtl(t, func(cx ctlCtx) { defragReclaimSpaceTest(cx, false) }, withQuorum(), withCfg(*cfg))
+}
+
+func TestCtlV3DefragClusterReclaimSpace(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.BasePeerScheme = "unix"
+	testCtl(t, func(cx ctlCtx) { defragReclaimSpaceTest(cx, true) }, withQuorum(), withCfg(*cfg))
+}
+
+func TestCtlV3DefragTimeout(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.GoFailEnabled = true
+	testCtl(t, defragTimeoutTest, withCfg(*cfg))
+}
+
+// TestCtlV3DefragStopGRPCService runs defragStopGRPCServiceTest with and
+// without --experimental-stop-grpc-service-on-defrag. The etcd v3.5.13 binary
+// these tests run against has no such flag, so the stop=true case skips and
+// StopGRPCServiceOnDefrag is not exercised until a binary supporting it is
+// used.
+func TestCtlV3DefragStopGRPCService(t *testing.T) {
+	for _, stop := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stop=%v", stop), func(t *testing.T) {
+			if stop && !etcdSupportsFlag("--experimental-stop-grpc-service-on-defrag") {
+				t.Skip("--experimental-stop-grpc-service-on-defrag is not supported by the etcd binary")
+			}
+			cfg := e2e.NewConfigNoTLS()
+			cfg.GoFailEnabled = true
+			cfg.StopGRPCServiceOnDefrag = stop
+			testCtl(t, func(cx ctlCtx) { defragStopGRPCServiceTest(cx, stop) }, withQuorum(), withCfg(*cfg))
+		})
+	}
+}
+
 func TestCtlV3DefragOffline(t *testing.T) {
 	testCtlWithOffline(t, maintenanceInitKeys, defragOfflineTest)
 }
@@ -50,15 +104,305 @@ func defragOnlineTest(cx ctlCtx) {
 	}
 }
 
+// defragLeaderNoLeaderChangeTest defragments the leader, which blocks its
+// backend for the duration, and ensures no election is triggered by it.
+func defragLeaderNoLeaderChangeTest(cx ctlCtx) {
+	for i := 0; i < 100; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("key-%d", i), strings.Repeat("a", 1024), ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	if err := ctlV3Compact(cx, 50, cx.compactPhysical); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	leaderIdx := cx.epc.WaitLeader(cx.t)
+	leader := cx.epc.Procs[leaderIdx]
+	leaderID, term := memberLeaderAndTerm(cx, leader)
+
+	cmdArgs := append(cx.prefixArgs(leader.EndpointsV3()), "defrag")
+	if err := e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "Finished defragmenting etcd member"); err != nil {
+		cx.t.Fatalf("defragLeaderNoLeaderChangeTest: defrag error (%v)", err)
+	}
+
+	for _, proc := range cx.epc.Procs {
+		gotLeader, gotTerm := memberLeaderAndTerm(cx, proc)
+		if gotLeader != leaderID || gotTerm != term {
+			cx.t.Fatalf("defragLeaderNoLeaderChangeTest: expected leader %x at term %d, got leader %x at term %d", leaderID, term, gotLeader, gotTerm)
+		}
+	}
+}
+
+// defragTimeoutTest slows the backend copy of a defrag down past the command
+// timeout, ensuring the defrag fails on the client side while the member keeps
+// its data and stays usable.
+func defragTimeoutTest(cx ctlCtx) {
+	member := cx.epc.Procs[0]
+	if !member.Failpoints().Available("defragBeforeCopy") {
+		cx.t.Skip("failpoint defragBeforeCopy is not available in the etcd binary")
+	}
+
+	var kvs []kv
+	for i := 0; i < 1000; i++ {
+		kvs = append(kvs, kv{fmt.Sprintf("key-%d", i), strings.Repeat("a", 1024)})
+	}
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	for i := range kvs {
+		if _, err := cli.Put(context.TODO(), kvs[i].key, kvs[i].val); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	delay := 2 * time.Second
+	if err := member.Failpoints().SetupHTTP(ctx, "defragBeforeCopy", fmt.Sprintf(`sleep("%s")`, delay)); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err := ctlV3OnlineDefragTimeout(cx, delay/4); err != nil {
+		cx.t.Fatalf("defragTimeoutTest: expected defrag to time out (%v)", err)
+	}
+	if err := member.Failpoints().DeactivateHTTP(ctx, "defragBeforeCopy"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	// the defrag carries on in the background, blocking requests until done
+	for i := range kvs {
+		resp, err := cli.Get(context.TODO(), kvs[i].key)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != kvs[i].val {
+			cx.t.Fatalf("defragTimeoutTest: expected %q to keep its value, got %+v", kvs[i].key, resp.Kvs)
+		}
+	}
+	if err := ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatalf("defragTimeoutTest: ctlV3Put error (%v)", err)
+	}
+	if err := ctlV3OnlineDefrag(cx); err != nil {
+		cx.t.Fatalf("defragTimeoutTest: ctlV3OnlineDefrag error (%v)", err)
+	}
+}
+
+// defragStopGRPCServiceTest pauses a defrag of the first member. Requests to the
+// member queue behind the defrag either way, but with stop set its gRPC health
+// service reports NOT_SERVING meanwhile, so health checking clients fail over
+// to the other members until the defrag is done.
+func defragStopGRPCServiceTest(cx ctlCtx, stop bool) {
+	member := cx.epc.Procs[0]
+	if !member.Failpoints().Available("defragBeforeCopy") {
+		cx.t.Skip("failpoint defragBeforeCopy is not available in the etcd binary")
+	}
+	if err := ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	memberCli := newClient(cx.t, member.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	health := healthpb.NewHealthClient(memberCli.ActiveConnection())
+	kv := pb.NewKVClient(newHealthCheckingConn(cx))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := member.Failpoints().SetupHTTP(ctx, "defragBeforeCopy", `sleep("3s")`); err != nil {
+		cx.t.Fatal(err)
+	}
+	defer member.Failpoints().DeactivateHTTP(ctx, "defragBeforeCopy")
+	errc := make(chan error, 1)
+	go func() { errc <- defragMember(cx, member.EndpointsV3()[0]) }()
+
+	// wait for the defrag to block reads on the member
+	for {
+		if ctx.Err() != nil {
+			cx.t.Fatal("reads on the member did not queue behind the defrag")
+		}
+		rctx, rcancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		_, err := memberCli.Get(rctx, "foo", clientv3.WithSerializable())
+		rcancel()
+		if err != nil {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	want := healthpb.HealthCheckResponse_SERVING
+	if stop {
+		want = healthpb.HealthCheckResponse_NOT_SERVING
+	}
+	hresp, err := health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if hresp.Status != want {
+		cx.t.Fatalf("expected health %v during the defrag, got %v", want, hresp.Status)
+	}
+	if stop {
+		// every request lands on the healthy members
+		for i := 0; i < 2*len(cx.epc.Procs); i++ {
+			rctx, rcancel := context.WithTimeout(ctx, time.Second)
+			_, err = kv.Range(rctx, &pb.RangeRequest{Key: []byte("foo"), Serializable: true})
+			rcancel()
+			if err != nil {
+				cx.t.Fatalf("#%d: expected the request to fail over (%v)", i, err)
+			}
+		}
+	}
+
+	if err = <-errc; err != nil {
+		cx.t.Fatal(err)
+	}
+	hresp, err = health.Check(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if hresp.Status != healthpb.HealthCheckResponse_SERVING {
+		cx.t.Fatalf("expected health SERVING after the defrag, got %v", hresp.Status)
+	}
+	if _, err = memberCli.Get(ctx, "foo", clientv3.WithSerializable()); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
+// newHealthCheckingConn dials every member with a round robin balancer that
+// skips members whose gRPC health service is not serving.
+func newHealthCheckingConn(cx ctlCtx) *grpc.ClientConn {
+	r := manual.NewBuilderWithScheme("e2e")
+	var addrs []resolver.Address
+	for _, ep := range cx.epc.EndpointsV3() {
+		u, err := url.Parse(ep)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		addrs = append(addrs, resolver.Address{Addr: u.Host})
+	}
+	r.InitialState(resolver.State{Addresses: addrs})
+	conn, err := grpc.Dial(r.Scheme()+":///",
+		grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultServiceConfig(`{"loadBalancingPolicy": "round_robin", "healthCheckConfig": {"serviceName": ""}}`),
+	)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	cx.t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// defragReclaimSpaceTest builds up compacted history and ensures
+// defragmenting every member, one by one or with --cluster, shrinks its
+// backend.
+func defragReclaimSpaceTest(cx ctlCtx, cluster bool) {
+	fillCompactedHistory(cx)
+
+	var err error
+	eps := cx.epc.EndpointsV3()
+	sizes, inUses := make([]int64, len(eps)), make([]int64, len(eps))
+	for i, ep := range eps {
+		if sizes[i], inUses[i], err = dbSize(cx, ep); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	if cluster {
+		err = defragCluster(cx)
+	} else {
+		for _, ep := range eps {
+			if err = defragMember(cx, ep); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		cx.t.Fatalf("defragReclaimSpaceTest: defrag error (%v)", err)
+	}
+
+	for i, ep := range eps {
+		size, inUse, err := dbSize(cx, ep)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if size >= sizes[i] {
+			cx.t.Fatalf("defragReclaimSpaceTest: expected the db size of %s to shrink from %d, got %d", ep, sizes[i], size)
+		}
+		if inUse > inUses[i] {
+			cx.t.Fatalf("defragReclaimSpaceTest: expected the db size in use of %s to stay at most %d, got %d", ep, inUses[i], inUse)
+		}
+		if inUse > size {
+			cx.t.Fatalf("defragReclaimSpaceTest: expected the db size in use of %s to fit its db size %d, got %d", ep, size, inUse)
+		}
+	}
+}
+
+// fillCompactedHistory overwrites keys to build up history, then compacts it
+// away, leaving free pages in the backends for a defrag to reclaim.
+func fillCompactedHistory(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	for i := 0; i < 5; i++ {
+		if err := fillEtcdWithDataCustom(context.TODO(), cli, fillEtcdWithDataOpts{
+			KeyCount:  100,
+			KeyPrefix: "key-",
+			ValueSize: 10 * 1024,
+		}); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	resp, err := ctlV3GetJSON(cx, "key-0")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = ctlV3Compact(cx, resp.Header.Revision, true); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
+// memberLeaderAndTerm returns the leader ID and raft term as seen by the given member.
+func memberLeaderAndTerm(cx ctlCtx, proc e2e.EtcdProcess) (uint64, uint64) {
+	resp, err := proc.Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, cx.epc.Cfg.EnableV2).Status()
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp) != 1 {
+		cx.t.Fatalf("expected a single endpoint status, got %d", len(resp))
+	}
+	return resp[0].Leader, resp[0].RaftTerm
+}
+
+// defragMember defragments the member serving ep.
+func defragMember(cx ctlCtx, ep string) error {
+	return ctlV3OnlineDefragWithEndpoints(cx, []string{ep})
+}
+
+// defragCluster defragments every member listed by the cluster with
+// "defrag --cluster".
+func defragCluster(cx ctlCtx) error {
+	// the cluster lists one member per endpoint
+	return ctlV3OnlineDefragWithEndpoints(cx, cx.epc.EndpointsV3(), "--cluster")
+}
+
 func ctlV3OnlineDefrag(cx ctlCtx) error {
-	cmdArgs := append(cx.PrefixArgs(), "defrag")
-	lines := make([]string, cx.epc.Cfg.ClusterSize)
+	return ctlV3OnlineDefragWithEndpoints(cx, cx.epc.EndpointsV3())
+}
+
+// ctlV3OnlineDefragWithEndpoints runs "defrag" with the given arguments
+// against eps, expecting one member defragmented per endpoint.
+func ctlV3OnlineDefragWithEndpoints(cx ctlCtx, eps []string, args ...string) error {
+	cmdArgs := append(cx.prefixArgs(eps), "defrag")
+	cmdArgs = append(cmdArgs, args...)
+	lines := make([]string, len(eps))
 	for i := range lines {
 		lines[i] = "Finished defragmenting etcd member"
 	}
 	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, lines...)
 }
 
+// ctlV3OnlineDefragTimeout runs an online defrag giving up after timeout,
+// expecting the timeout to expire before the defrag finishes.
+func ctlV3OnlineDefragTimeout(cx ctlCtx, timeout time.Duration) error {
+	// cx is a copy, the timeout doesn't leak into other commands
+	cx.commandTimeout = timeout
+	cmdArgs := append(cx.PrefixArgs(), "defrag")
+	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "context deadline exceeded")
+}
+
 func ctlV3OfflineDefrag(cx ctlCtx) error {
 	cmdArgs := append(cx.PrefixArgsUtl(), "defrag", "--data-dir", cx.dataDir)
 	lines := []string{"finished defragmenting directory"}
@@ -18,16 +18,23 @@ import (
 	"context"
 	"fmt"
 	"net/url"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3EndpointHealth(t *testing.T) { testCtl(t, endpointHealthTest, withQuorum()) }
 func TestCtlV3EndpointStatus(t *testing.T) { testCtl(t, endpointStatusTest, withQuorum()) }
 func TestCtlV3EndpointHashKV(t *testing.T) { testCtl(t, endpointHashKVTest, withQuorum()) }
+func TestCtlV3EndpointStatusJSON(t *testing.T) {
+	testCtl(t, endpointStatusJSONTest, withQuorum())
+}
+func TestCtlV3EndpointStatusUnreachable(t *testing.T) {
+	testCtl(t, endpointStatusUnreachableTest, withQuorum(), withCommandTimeout(3*time.Second))
+}
 
 func endpointHealthTest(cx ctlCtx) {
 	if err := ctlV3EndpointHealth(cx); err != nil {
@@ -44,6 +51,38 @@ func ctlV3EndpointHealth(cx ctlCtx) error {
 	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, lines...)
 }
 
+// waitClusterHealthy polls "endpoint health" on every cluster endpoint until
+// all of them report healthy, or fails listing the endpoints still unhealthy
+// once timeout elapses.
+func waitClusterHealthy(cx ctlCtx, timeout time.Duration) error {
+	eps := cx.epc.EndpointsV3()
+	deadline := time.Now().Add(timeout)
+	for {
+		proc, err := e2e.SpawnCmd(append(cx.prefixArgs(eps), "endpoint", "health"), cx.envMap)
+		if err != nil {
+			return err
+		}
+		proc.Wait()
+		// exits with an error while any endpoint is unhealthy
+		proc.Close()
+
+		out := strings.Join(proc.Lines(), "\n")
+		var unhealthy []string
+		for _, ep := range eps {
+			if !strings.Contains(out, ep+" is healthy") {
+				unhealthy = append(unhealthy, ep)
+			}
+		}
+		if len(unhealthy) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("endpoints %v still unhealthy after %v", unhealthy, timeout)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
 func endpointStatusTest(cx ctlCtx) {
 	if err := ctlV3EndpointStatus(cx); err != nil {
 		cx.t.Fatalf("endpointStatusTest ctlV3EndpointStatus error (%v)", err)
@@ -51,13 +90,131 @@ func endpointStatusTest(cx ctlCtx) {
 }
 
 func ctlV3EndpointStatus(cx ctlCtx) error {
-	cmdArgs := append(cx.PrefixArgs(), "endpoint", "status")
-	var eps []string
-	for _, ep := range cx.epc.EndpointsV3() {
+	return ctlV3EndpointStatusWithErrors(cx, cx.epc.EndpointsV3(), nil)
+}
+
+// endpointStatusJSONTest ensures every member of the decoded member list
+// reports a non-empty backend in its decoded endpoint status.
+func endpointStatusJSONTest(cx ctlCtx) {
+	members, err := getMemberListJSON(cx)
+	if err != nil {
+		cx.t.Fatalf("endpointStatusJSONTest getMemberListJSON error (%v)", err)
+	}
+	ids := make(map[uint64]bool)
+	for _, m := range members.Members {
+		ids[m.ID] = true
+	}
+	statuses, err := getEndpointStatusJSON(cx)
+	if err != nil {
+		cx.t.Fatalf("endpointStatusJSONTest getEndpointStatusJSON error (%v)", err)
+	}
+	if len(statuses) != len(cx.epc.EndpointsV3()) {
+		cx.t.Fatalf("expected %d endpoint statuses, got %d", len(cx.epc.EndpointsV3()), len(statuses))
+	}
+	for _, st := range statuses {
+		if st.Status == nil {
+			cx.t.Fatalf("expected a status for %s", st.Endpoint)
+		}
+		if !ids[st.Status.Header.MemberId] {
+			cx.t.Fatalf("expected %s to be served by a listed member, got member %x", st.Endpoint, st.Status.Header.MemberId)
+		}
+		if st.Status.DbSize <= 0 {
+			cx.t.Fatalf("expected a positive db size for %s, got %d", st.Endpoint, st.Status.DbSize)
+		}
+	}
+}
+
+// endpointStatus is a single entry of "endpoint status --write-out=json".
+type endpointStatus struct {
+	Endpoint string
+	Status   *clientv3.StatusResponse
+}
+
+func getEndpointStatusJSON(cx ctlCtx) ([]endpointStatus, error) {
+	return runJSONCmd[[]endpointStatus](cx, "endpoint", "status")
+}
+
+// dbSize returns the backend size and the part of it in use of the member
+// serving ep.
+func dbSize(cx ctlCtx, ep string) (size, inUse int64, err error) {
+	statuses, err := runJSONCmdWithEndpoints[[]endpointStatus](cx, []string{ep}, "endpoint", "status")
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(statuses) != 1 || statuses[0].Status == nil {
+		return 0, 0, fmt.Errorf("expected the status of %s, got %+v", ep, statuses)
+	}
+	return statuses[0].Status.DbSize, statuses[0].Status.DbSizeInUse, nil
+}
+
+// endpointStatusUnreachableTest ensures an unreachable endpoint is reported as
+// such without hiding the status of the reachable ones.
+func endpointStatusUnreachableTest(cx ctlCtx) {
+	badEP := fmt.Sprintf("%s://localhost:%d", cx.epc.Cfg.ClientScheme(), e2e.EtcdProcessBasePort+99)
+	eps := append([]string{badEP}, cx.epc.EndpointsV3()...)
+	if err := ctlV3EndpointStatusWithErrors(cx, eps, []string{badEP}); err != nil {
+		cx.t.Fatalf("endpointStatusUnreachableTest ctlV3EndpointStatus error (%v)", err)
+	}
+}
+
+// ctlV3EndpointStatusWithErrors runs "endpoint status" against eps and expects
+// a status error for every endpoint in errEPs and a status entry for the rest.
+func ctlV3EndpointStatusWithErrors(cx ctlCtx, eps, errEPs []string) error {
+	cmdArgs := append(cx.prefixArgs(eps), "endpoint", "status")
+	failed := make(map[string]bool)
+	var lines []string
+	// errors are printed as they happen, before the status table
+	for _, ep := range errEPs {
+		failed[ep] = true
+		lines = append(lines, fmt.Sprintf("the status of endpoint %s", ep))
+	}
+	for _, ep := range eps {
+		if failed[ep] {
+			continue
+		}
 		u, _ := url.Parse(ep)
-		eps = append(eps, u.Host)
+		lines = append(lines, u.Host)
+	}
+	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, lines...)
+}
+
+// endpointHashKV is a single entry of "endpoint hashkv --write-out=json".
+type endpointHashKV struct {
+	Endpoint string
+	HashKV   *clientv3.HashKVResponse
+}
+
+// compareHashKV gets the hash of the keyspace of every member at the latest
+// revision all of them have applied, and reports whether the hashes match.
+// The hashes are keyed by endpoint.
+func compareHashKV(cx ctlCtx) (consistent bool, hashes map[string]int64, err error) {
+	statuses, err := getEndpointStatusJSON(cx)
+	if err != nil {
+		return false, nil, err
+	}
+	var rev int64
+	for i, st := range statuses {
+		if i == 0 || st.Status.Header.Revision < rev {
+			rev = st.Status.Header.Revision
+		}
+	}
+
+	resps, err := runJSONCmd[[]endpointHashKV](cx, "endpoint", "hashkv", "--cluster", fmt.Sprintf("--rev=%d", rev))
+	if err != nil {
+		return false, nil, err
+	}
+	if len(resps) != len(statuses) {
+		return false, nil, fmt.Errorf("expected %d hashes, got %+v", len(statuses), resps)
+	}
+	consistent = true
+	hashes = make(map[string]int64, len(resps))
+	for _, resp := range resps {
+		hashes[resp.Endpoint] = int64(resp.HashKV.Hash)
+		if resp.HashKV.Hash != resps[0].HashKV.Hash {
+			consistent = false
+		}
 	}
-	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, eps...)
+	return consistent, hashes, nil
 }
 
 func endpointHashKVTest(cx ctlCtx) {
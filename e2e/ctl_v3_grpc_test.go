@@ -26,8 +26,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestAuthority(t *testing.T) {
@@ -15,12 +15,22 @@
 package e2e
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 func TestCtlV3Put(t *testing.T)          { testCtl(t, putTest, withDialTimeout(7*time.Second)) }
@@ -36,6 +46,8 @@ func TestCtlV3PutClientTLSFlagByEnv(t *testing.T) {
 }
 func TestCtlV3PutIgnoreValue(t *testing.T) { testCtl(t, putTestIgnoreValue) }
 func TestCtlV3PutIgnoreLease(t *testing.T) { testCtl(t, putTestIgnoreLease) }
+func TestCtlV3PutEmptyValue(t *testing.T)  { testCtl(t, putTestEmptyValue) }
+func TestCtlV3PutSameValue(t *testing.T)   { testCtl(t, putTestSameValue) }
 
 func TestCtlV3Get(t *testing.T)          { testCtl(t, getTest) }
 func TestCtlV3GetNoTLS(t *testing.T)     { testCtl(t, getTest, withCfg(*e2e.NewConfigNoTLS())) }
@@ -47,8 +59,34 @@ func TestCtlV3GetPeerTLS(t *testing.T) { testCtl(t, getTest, withCfg(*e2e.NewCon
 func TestCtlV3GetTimeout(t *testing.T) { testCtl(t, getTest, withDialTimeout(0)) }
 func TestCtlV3GetQuorum(t *testing.T)  { testCtl(t, getTest, withQuorum()) }
 
+func TestCtlV3GetLinearizableFromFollower(t *testing.T) {
+	testCtl(t, getLinearizableFromFollowerTest, withQuorum())
+}
+
+func TestCtlV3ReadYourWrites(t *testing.T) {
+	testCtl(t, readYourWritesTest, withQuorum())
+}
+
+func TestCtlV3PutMaxRequestBytes(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.MaxRequestBytes = 64 * 1024
+	testCtl(t, putMaxRequestBytesTest, withCfg(*cfg))
+}
+
+func TestCtlV3GetSerializableSnapshot(t *testing.T) {
+	testCtl(t, getSerializableSnapshotTest)
+}
+
+func TestCtlV3GetSpecialCharKeys(t *testing.T) { testCtl(t, getSpecialCharKeysTest) }
+func TestCtlV3GetSortByValue(t *testing.T)     { testCtl(t, getSortByValueTest) }
+func TestCtlV3GetCreateRevRange(t *testing.T)  { testCtl(t, getCreateRevRangeTest) }
+func TestCtlV3GetCountFilled(t *testing.T)     { testCtl(t, getCountFilledTest) }
+func TestCtlV3GetMissingKeyRev(t *testing.T)   { testCtl(t, getMissingKeyRevTest) }
+func TestCtlV3GetLimitZero(t *testing.T)       { testCtl(t, getLimitZeroTest) }
+
 func TestCtlV3GetFormat(t *testing.T)    { testCtl(t, getFormatTest) }
 func TestCtlV3GetRev(t *testing.T)       { testCtl(t, getRevTest) }
+func TestCtlV3GetFutureRev(t *testing.T) { testCtl(t, getFutureRevTest) }
 func TestCtlV3GetKeysOnly(t *testing.T)  { testCtl(t, getKeysOnlyTest) }
 func TestCtlV3GetCountOnly(t *testing.T) { testCtl(t, getCountOnlyTest) }
 
@@ -136,6 +174,83 @@ func putTestIgnoreLease(cx ctlCtx) {
 	}
 }
 
+// putTestEmptyValue ensures a key with an empty value is present and
+// distinguishable from a deleted key, which returns no kv at all.
+func putTestEmptyValue(cx ctlCtx) {
+	if err := ctlV3Put(cx, "foo", "", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	cmdArgs := append(cx.PrefixArgs(), "get", "foo", "--write-out=fields")
+	if err := e2e.SpawnWithExpects(cmdArgs, cx.envMap, `"Key" : "foo"`, `"Version" : 1`, `"Value" : ""`, `"Count" : 1`); err != nil {
+		cx.t.Fatalf("putTestEmptyValue: get error (%v)", err)
+	}
+	if err := ctlV3GetCount(cx, "foo", 1); err != nil {
+		cx.t.Fatalf("putTestEmptyValue: ctlV3GetCount error (%v)", err)
+	}
+
+	if err := ctlV3Del(cx, []string{"foo"}, 1); err != nil {
+		cx.t.Fatalf("putTestEmptyValue: ctlV3Del error (%v)", err)
+	}
+	if err := ctlV3GetCount(cx, "foo", 0); err != nil {
+		cx.t.Fatalf("putTestEmptyValue: ctlV3GetCount error (%v)", err)
+	}
+	cmdArgs = append(cx.PrefixArgs(), "get", "foo", "--write-out=fields")
+	if err := e2e.SpawnWithExpects(cmdArgs, cx.envMap, `"Key" : "foo"`); err == nil {
+		cx.t.Fatal("putTestEmptyValue: deleted key should not be returned")
+	}
+}
+
+// putTestSameValue puts a key to the value it already holds and ensures the
+// write is not deduplicated: it gets a new mod revision and version while the
+// create revision is kept, and a watcher observes both puts.
+func putTestSameValue(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	events, errc, err := watchPrefix(ctx, cli, "foo")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	var revs []int64
+	for i := 0; i < 2; i++ {
+		rev, err := putReturningRev(cli, "foo", "bar")
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		revs = append(revs, rev)
+	}
+	if revs[1] <= revs[0] {
+		cx.t.Fatalf("expected the repeated put to advance the revision past %d, got %d", revs[0], revs[1])
+	}
+
+	resp, err := cli.Get(context.TODO(), "foo")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 {
+		cx.t.Fatalf("expected one kv, got %+v", resp.Kvs)
+	}
+	if kv := resp.Kvs[0]; kv.CreateRevision != revs[0] || kv.ModRevision != revs[1] || kv.Version != 2 {
+		cx.t.Fatalf("expected create revision %d, mod revision %d and version 2, got %d, %d and %d",
+			revs[0], revs[1], kv.CreateRevision, kv.ModRevision, kv.Version)
+	}
+
+	for i, rev := range revs {
+		want := WatchEvent{Type: mvccpb.PUT, Key: "foo", Value: "bar", Revision: rev}
+		select {
+		case ev := <-events:
+			if ev != want {
+				cx.t.Fatalf("#%d: expected %+v, got %+v", i, want, ev)
+			}
+		case err = <-errc:
+			cx.t.Fatalf("#%d: watch failed (%v)", i, err)
+		case <-ctx.Done():
+			cx.t.Fatalf("#%d: timed out waiting for %+v", i, want)
+		}
+	}
+}
+
 func getTest(cx ctlCtx) {
 	var (
 		kvs    = []kv{{"key1", "val1"}, {"key2", "val2"}, {"key3", "val3"}}
@@ -172,6 +287,476 @@ func getTest(cx ctlCtx) {
 	}
 }
 
+// getLinearizableFromFollowerTest ensures that a linearizable read served by a
+// follower observes the latest write committed through the leader, while a
+// serializable read on the same follower is served locally even without quorum.
+func getLinearizableFromFollowerTest(cx ctlCtx) {
+	leadIdx := cx.epc.WaitLeader(cx.t)
+	leaderEP := cx.epc.Procs[leadIdx].EndpointsV3()[0]
+	followerIdx := (leadIdx + 1) % len(cx.epc.Procs)
+	followerEP := cx.epc.Procs[followerIdx].EndpointsV3()[0]
+
+	for i := 0; i < 10; i++ {
+		val := fmt.Sprintf("bar%d", i)
+		cmdArgs := append(cx.prefixArgs([]string{leaderEP}), "put", "foo", val)
+		if err := e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "OK"); err != nil {
+			cx.t.Fatalf("getLinearizableFromFollowerTest #%d: put error (%v)", i, err)
+		}
+		if err := ctlV3GetFromEndpoint(cx, followerEP, "l", []string{"foo"}, kv{"foo", val}); err != nil {
+			cx.t.Fatalf("getLinearizableFromFollowerTest #%d: linearizable get error (%v)", i, err)
+		}
+	}
+
+	// leave the follower without quorum
+	for i, proc := range cx.epc.Procs {
+		if i == followerIdx {
+			continue
+		}
+		if err := proc.Stop(); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	if err := ctlV3GetFromEndpoint(cx, followerEP, "s", []string{"foo"}, kv{"foo", "bar9"}); err != nil {
+		cx.t.Fatalf("getLinearizableFromFollowerTest: serializable get error (%v)", err)
+	}
+	err := ctlV3GetFromEndpoint(cx, followerEP, "l", []string{"foo", "--command-timeout=2s"}, kv{"foo", "bar9"})
+	if err == nil {
+		cx.t.Fatal("getLinearizableFromFollowerTest: expected linearizable get without quorum to fail")
+	}
+}
+
+// readYourWritesTest ensures a single client always reads back its own last
+// write with a linearizable get, under concurrent writers and a leader change.
+func readYourWritesTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	bgCli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	const writers = 3
+	// puts may fail around the leader change, but each writer must make progress
+	var putErrs [writers]struct {
+		failed, succeeded int
+		last              error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ctx.Err() == nil; i++ {
+				_, err := bgCli.Put(ctx, fmt.Sprintf("bg-%d", w), fmt.Sprintf("%d", i))
+				switch {
+				case err == nil:
+					putErrs[w].succeeded++
+				case ctx.Err() == nil:
+					putErrs[w].failed++
+					putErrs[w].last = err
+				}
+			}
+		}(w)
+	}
+	stopWriters := func() {
+		cancel()
+		wg.Wait()
+	}
+	defer stopWriters()
+
+	const maxPutRetries = 5
+	for i, retries := 0, 0; i < 100; i++ {
+		if i == 50 && retries == 0 {
+			if err := moveLeaderToFollower(cx); err != nil {
+				cx.t.Fatal(err)
+			}
+		}
+		val := fmt.Sprintf("val-%d", i)
+		if _, err := cli.Put(context.TODO(), "key", val); err != nil {
+			// the outcome of a failed put is unknown, retry it
+			if retries++; retries > maxPutRetries {
+				cx.t.Fatalf("readYourWritesTest #%d: put failed %d times (%v)", i, retries, err)
+			}
+			cx.t.Logf("readYourWritesTest #%d: put error (%v), retrying", i, err)
+			i--
+			continue
+		}
+		retries = 0
+		var resp *clientv3.GetResponse
+		var err error
+		for r := 0; r < 5; r++ {
+			if resp, err = cli.Get(context.TODO(), "key"); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			cx.t.Fatalf("readYourWritesTest #%d: get error (%v)", i, err)
+		}
+		if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != val {
+			cx.t.Fatalf("readYourWritesTest #%d: expected %q, got %+v", i, val, resp.Kvs)
+		}
+	}
+
+	stopWriters()
+	for w, e := range putErrs {
+		if e.failed > 0 {
+			cx.t.Logf("readYourWritesTest: writer %d had %d failed puts, last error (%v)", w, e.failed, e.last)
+		}
+		if e.succeeded == 0 {
+			cx.t.Fatalf("readYourWritesTest: writer %d made no progress, last error (%v)", w, e.last)
+		}
+	}
+}
+
+// putMaxRequestBytesTest puts values sized so that the raft request carrying
+// them is exactly at, and one byte over, --max-request-bytes.
+func putMaxRequestBytesTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	sresp, err := cli.Status(context.TODO(), cx.epc.EndpointsV3()[0])
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	limit := int(cx.epc.Cfg.MaxRequestBytes)
+	key := "foo"
+
+	val := strings.Repeat("a", putValueSizeForRequestSize(sresp.Header.MemberId, key, limit))
+	if _, err = cli.Put(context.TODO(), key, val); err != nil {
+		cx.t.Fatalf("putMaxRequestBytesTest: put of a %d bytes request failed (%v)", limit, err)
+	}
+	resp, err := cli.Get(context.TODO(), key)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != val {
+		cx.t.Fatalf("putMaxRequestBytesTest: value of %d bytes not stored", len(val))
+	}
+
+	val = strings.Repeat("a", putValueSizeForRequestSize(sresp.Header.MemberId, key, limit+1))
+	if _, err = cli.Put(context.TODO(), key, val); rpctypes.Error(err) != rpctypes.ErrRequestTooLarge {
+		cx.t.Fatalf("putMaxRequestBytesTest: expected %v for a %d bytes request, got %v", rpctypes.ErrRequestTooLarge, limit+1, err)
+	}
+}
+
+// putValueSizeForRequestSize returns the value size for which the raft request
+// of a put of key, as proposed by the member memberID, is exactly size bytes.
+// The server checks --max-request-bytes against this request, which includes a
+// header whose request ID is prefixed with the low 16 bits of the member ID and
+// followed by a millisecond timestamp.
+func putValueSizeForRequestSize(memberID uint64, key string, size int) int {
+	req := etcdserverpb.InternalRaftRequest{
+		Header: &etcdserverpb.RequestHeader{ID: uint64(uint16(memberID))<<48 | 1<<47},
+		Put:    &etcdserverpb.PutRequest{Key: []byte(key)},
+	}
+	n := size - req.Size()
+	// the encoded value length grows with the value, shrink until it fits
+	for ; n > 0; n-- {
+		req.Put.Value = make([]byte, n)
+		if req.Size() <= size {
+			break
+		}
+	}
+	return n
+}
+
+// getSerializableSnapshotTest ensures a serializable range read observes a
+// single revision, never a partially applied batch, while batches of keys are
+// being overwritten in single transactions.
+func getSerializableSnapshotTest(cx ctlCtx) {
+	const batchSize = 10
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	putBatch := func(ctx context.Context, n int) error {
+		ops := make([]clientv3.Op, batchSize)
+		for i := range ops {
+			ops[i] = clientv3.OpPut(fmt.Sprintf("batch/%d", i), strconv.Itoa(n))
+		}
+		_, err := cli.Txn(ctx).Then(ops...).Commit()
+		return err
+	}
+	if err := putBatch(context.TODO(), 0); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		// stops on the first error, which is the cancellation at the end of the test
+		for n := 1; ; n++ {
+			if err := putBatch(ctx, n); err != nil {
+				return
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		<-donec
+	}()
+
+	for i := 0; i < 20; i++ {
+		resp, err := ctlV3GetJSON(cx, "batch/", "--prefix", "--consistency=s")
+		if err != nil {
+			cx.t.Fatalf("getSerializableSnapshotTest #%d: get error (%v)", i, err)
+		}
+		if len(resp.Kvs) != batchSize {
+			cx.t.Fatalf("getSerializableSnapshotTest #%d: expected %d keys, got %d", i, batchSize, len(resp.Kvs))
+		}
+		for _, kv := range resp.Kvs {
+			if kv.ModRevision != resp.Kvs[0].ModRevision || string(kv.Value) != string(resp.Kvs[0].Value) {
+				cx.t.Fatalf("getSerializableSnapshotTest #%d: partial batch observed, %q=%q at %d and %q=%q at %d", i,
+					resp.Kvs[0].Key, resp.Kvs[0].Value, resp.Kvs[0].ModRevision, kv.Key, kv.Value, kv.ModRevision)
+			}
+		}
+		if resp.Kvs[0].ModRevision > resp.Header.Revision {
+			cx.t.Fatalf("getSerializableSnapshotTest #%d: key modified at %d after read revision %d", i, resp.Kvs[0].ModRevision, resp.Header.Revision)
+		}
+	}
+}
+
+// getSpecialCharKeysTest ensures keys with slashes, spaces and unicode
+// characters round trip unchanged through the etcdctl arguments, and that
+// prefix gets over slash separated keys stop at the directory boundary.
+func getSpecialCharKeysTest(cx ctlCtx) {
+	kvs := []kv{
+		{"/dir/a", "slash"},
+		{"/dir/b c", "space"},
+		{"/dir/sub/ключ/キー", "unicode"},
+		{"/dir/tab\tquote\"'", "tab and quotes"},
+		{"/dirx", "sibling"},
+	}
+	for i := range kvs {
+		if err := ctlV3Put(cx, kvs[i].key, kvs[i].val, ""); err != nil {
+			cx.t.Fatalf("put %q error (%v)", kvs[i].key, err)
+		}
+	}
+
+	for i := range kvs {
+		resp, err := ctlV3GetJSON(cx, kvs[i].key)
+		if err != nil {
+			cx.t.Fatalf("get %q error (%v)", kvs[i].key, err)
+		}
+		if len(resp.Kvs) != 1 || string(resp.Kvs[0].Key) != kvs[i].key || string(resp.Kvs[0].Value) != kvs[i].val {
+			cx.t.Fatalf("expected exactly %q=%q, got %+v", kvs[i].key, kvs[i].val, resp.Kvs)
+		}
+	}
+
+	resp, err := ctlV3GetJSON(cx, "--prefix", "/dir/")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	want := kvs[:len(kvs)-1]
+	if len(resp.Kvs) != len(want) {
+		cx.t.Fatalf("expected %d keys under /dir/, got %+v", len(want), resp.Kvs)
+	}
+	// gets are sorted by key, matching the order of kvs
+	for i := range want {
+		if string(resp.Kvs[i].Key) != want[i].key || string(resp.Kvs[i].Value) != want[i].val {
+			cx.t.Fatalf("expected %q=%q at #%d, got %q=%q", want[i].key, want[i].val, i, resp.Kvs[i].Key, resp.Kvs[i].Value)
+		}
+	}
+}
+
+// getSortByValueTest ensures gets sorted by value in descending order return
+// the kvs by decreasing value. The order of keys sharing a value is left
+// unspecified, so they are compared as a set.
+func getSortByValueTest(cx ctlCtx) {
+	kvs := []kv{{"key1", "b"}, {"key2", "d"}, {"key3", "a"}, {"key4", "c"}, {"key5", "b"}, {"key6", "d"}}
+	for i := range kvs {
+		if err := ctlV3Put(cx, kvs[i].key, kvs[i].val, ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	resp, err := ctlV3GetJSON(cx, "key", "--prefix", "--sort-by=VALUE", "--order=DESCEND")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Kvs) != len(kvs) {
+		cx.t.Fatalf("expected %d kvs, got %+v", len(kvs), resp.Kvs)
+	}
+	want := []struct {
+		val  string
+		keys []string
+	}{
+		{"d", []string{"key2", "key6"}},
+		{"c", []string{"key4"}},
+		{"b", []string{"key1", "key5"}},
+		{"a", []string{"key3"}},
+	}
+	i := 0
+	for _, w := range want {
+		var keys []string
+		for _, kv := range resp.Kvs[i : i+len(w.keys)] {
+			if string(kv.Value) != w.val {
+				cx.t.Fatalf("expected value %q at #%d, got %q=%q", w.val, i, kv.Key, kv.Value)
+			}
+			keys = append(keys, string(kv.Key))
+			i++
+		}
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, w.keys) {
+			cx.t.Fatalf("expected keys %q with value %q, got %q", w.keys, w.val, keys)
+		}
+	}
+}
+
+// getCreateRevRangeTest creates key0 to key9 at revisions 2 to 11 and ensures
+// the create revision bounds are inclusive and ignore later modifications.
+func getCreateRevRangeTest(cx ctlCtx) {
+	for i := 0; i < 10; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("key%d", i), "v", ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	// moves the mod revision of key0 past every create revision
+	if err := ctlV3Put(cx, "key0", "updated", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	tests := []struct {
+		minRev, maxRev int64
+		wkeys          []string
+	}{
+		{4, 7, []string{"key2", "key3", "key4", "key5"}},
+		{5, 5, []string{"key3"}},
+		{10, 0, []string{"key8", "key9"}},
+		{0, 3, []string{"key0", "key1"}},
+		{12, 0, nil},
+	}
+	for i, tt := range tests {
+		resp, err := ctlV3GetCreateRevRange(cx, "key", tt.minRev, tt.maxRev)
+		if err != nil {
+			cx.t.Fatalf("#%d: %v", i, err)
+		}
+		var keys []string
+		for _, kv := range resp.Kvs {
+			if kv.CreateRevision < tt.minRev || (tt.maxRev > 0 && kv.CreateRevision > tt.maxRev) {
+				cx.t.Errorf("#%d: key %q created at %d is out of [%d, %d]", i, kv.Key, kv.CreateRevision, tt.minRev, tt.maxRev)
+			}
+			keys = append(keys, string(kv.Key))
+		}
+		if !reflect.DeepEqual(keys, tt.wkeys) {
+			cx.t.Errorf("#%d: expected keys %v, got %v", i, tt.wkeys, keys)
+		}
+	}
+}
+
+// ctlV3GetCreateRevRange gets the keys with the given prefix whose create
+// revision is within [minRev, maxRev]. A zero bound is not applied.
+func ctlV3GetCreateRevRange(cx ctlCtx, prefix string, minRev, maxRev int64) (*etcdserverpb.RangeResponse, error) {
+	args := []string{prefix, "--prefix"}
+	if minRev > 0 {
+		args = append(args, fmt.Sprintf("--min-create-rev=%d", minRev))
+	}
+	if maxRev > 0 {
+		args = append(args, fmt.Sprintf("--max-create-rev=%d", maxRev))
+	}
+	return ctlV3GetJSON(cx, args...)
+}
+
+// getCountFilledTest fills the cluster with thousands of keys and ensures a
+// range over their prefix counts every one of them.
+func getCountFilledTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.cfg.ClientTLS, cx.cfg.IsClientAutoTLS)
+	err := fillEtcdWithDataCustom(context.TODO(), cli, fillEtcdWithDataOpts{
+		KeyCount:    5000,
+		KeyPrefix:   "filled/",
+		ValueSize:   16,
+		Concurrency: 20,
+	})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = ctlV3Put(cx, "other", "v", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	resp, err := ctlV3GetJSON(cx, "filled/", "--prefix", "--count-only")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if resp.Count != 5000 {
+		cx.t.Fatalf("expected 5000 keys, got %d", resp.Count)
+	}
+}
+
+// getMissingKeyRevTest ensures a get of a key that does not exist still
+// reports the current revision in its header, without advancing it.
+func getMissingKeyRevTest(cx ctlCtx) {
+	for i := 0; i < 3; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("key%d", i), "v", ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	resp, err := ctlV3GetJSON(cx, "key0")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	rev := resp.Header.Revision
+	if rev < 4 {
+		cx.t.Fatalf("expected revision at least 4 after 3 puts, got %d", rev)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err = ctlV3GetJSON(cx, "missing")
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if len(resp.Kvs) != 0 || resp.Count != 0 {
+			cx.t.Fatalf("expected no keys, got %d keys with count %d", len(resp.Kvs), resp.Count)
+		}
+		if resp.Header.Revision != rev {
+			cx.t.Fatalf("#%d: expected header revision %d, got %d", i, rev, resp.Header.Revision)
+		}
+	}
+}
+
+// getLimitZeroTest ensures a limit of 0 means no limit, unlike a limit of 1.
+func getLimitZeroTest(cx ctlCtx) {
+	const total = 5
+	for i := 0; i < total; i++ {
+		if err := ctlV3Put(cx, fmt.Sprintf("key%d", i), "v", ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	for _, tt := range []struct {
+		limit    int
+		wantKvs  int
+		wantMore bool
+	}{
+		{limit: 0, wantKvs: total},
+		{limit: 1, wantKvs: 1, wantMore: true},
+	} {
+		resp, err := ctlV3GetJSON(cx, "key", "--prefix", fmt.Sprintf("--limit=%d", tt.limit))
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if len(resp.Kvs) != tt.wantKvs || resp.More != tt.wantMore || resp.Count != total {
+			cx.t.Fatalf("limit %d: expected %d keys, more %v and count %d, got %d keys, more %v and count %d",
+				tt.limit, tt.wantKvs, tt.wantMore, total, len(resp.Kvs), resp.More, resp.Count)
+		}
+	}
+}
+
+// ctlV3GetJSON runs "get" with the given arguments and decodes its JSON output.
+func ctlV3GetJSON(cx ctlCtx, args ...string) (*etcdserverpb.RangeResponse, error) {
+	cmdArgs := append(cx.PrefixArgs(), "--write-out", "json", "get")
+	cmdArgs = append(cmdArgs, args...)
+	proc, err := e2e.SpawnCmd(cmdArgs, cx.envMap)
+	if err != nil {
+		return nil, err
+	}
+	txt, err := proc.Expect("header")
+	if err != nil {
+		return nil, err
+	}
+	if err = proc.Close(); err != nil {
+		return nil, err
+	}
+	var resp etcdserverpb.RangeResponse
+	if err = json.NewDecoder(strings.NewReader(txt)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
 func getFormatTest(cx ctlCtx) {
 	if err := ctlV3Put(cx, "abc", "123", ""); err != nil {
 		cx.t.Fatal(err)
@@ -229,6 +814,70 @@ func getRevTest(cx ctlCtx) {
 	}
 }
 
+// getFutureRevTest pins the future revision boundary. A get at a revision
+// beyond the current one always fails with the future revision error. When it
+// races a put that creates that revision, the get either fails with the same
+// error or observes the put; it never returns anything else.
+func getFutureRevTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	presp, err := cli.Put(context.TODO(), "key", "val")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	futureRev := strconv.FormatInt(presp.Header.Revision+1, 10)
+	if err = ctlV3GetWithErr(cx, []string{"key", "--rev", futureRev}, []string{"required revision is a future revision"}); err != nil {
+		cx.t.Fatalf("getFutureRevTest: ctlV3GetWithErr error (%v)", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		rev := presp.Header.Revision + 1
+		val := fmt.Sprintf("val-%d", i)
+		putc := make(chan error, 1)
+		go func() {
+			var perr error
+			presp, perr = cli.Put(context.TODO(), "key", val)
+			putc <- perr
+		}()
+		gresp, gerr := getAtRev(cli, "key", rev)
+		if perr := <-putc; perr != nil {
+			cx.t.Fatal(perr)
+		}
+		if presp.Header.Revision != rev {
+			cx.t.Fatalf("getFutureRevTest #%d: expected put at revision %d, got %d", i, rev, presp.Header.Revision)
+		}
+		switch {
+		case gerr == rpctypes.ErrFutureRev:
+		case gerr != nil:
+			cx.t.Fatalf("getFutureRevTest #%d: unexpected error (%v)", i, gerr)
+		case len(gresp.Kvs) != 1 || string(gresp.Kvs[0].Value) != val:
+			cx.t.Fatalf("getFutureRevTest #%d: expected %q at revision %d, got %+v", i, val, rev, gresp.Kvs)
+		}
+		// once the put has returned, the revision is no longer in the future
+		if gresp, gerr = getAtRev(cli, "key", rev); gerr != nil || len(gresp.Kvs) != 1 || string(gresp.Kvs[0].Value) != val {
+			cx.t.Fatalf("getFutureRevTest #%d: expected %q at revision %d, got %+v (%v)", i, val, rev, gresp, gerr)
+		}
+	}
+}
+
+// getAtRev gets key at the given revision, translating the error to its
+// client side representation.
+func getAtRev(cli *clientv3.Client, key string, rev int64) (*clientv3.GetResponse, error) {
+	resp, err := cli.Get(context.TODO(), key, clientv3.WithRev(rev))
+	if err != nil {
+		return nil, rpctypes.Error(err)
+	}
+	return resp, nil
+}
+
+// putReturningRev puts key and returns the revision of the write.
+func putReturningRev(cli *clientv3.Client, key, val string) (int64, error) {
+	resp, err := cli.Put(context.TODO(), key, val)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Header.Revision, nil
+}
+
 func getKeysOnlyTest(cx ctlCtx) {
 	if err := ctlV3Put(cx, "key", "val", ""); err != nil {
 		cx.t.Fatal(err)
@@ -374,6 +1023,24 @@ func ctlV3Get(cx ctlCtx, args []string, kvs ...kv) error {
 	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, lines...)
 }
 
+// ctlV3GetFromEndpoint runs "get" command against a single endpoint
+// with the given consistency ("l" or "s").
+func ctlV3GetFromEndpoint(cx ctlCtx, ep, consistency string, args []string, kvs ...kv) error {
+	cmdArgs := append(cx.prefixArgs([]string{ep}), "get", "--consistency", consistency)
+	cmdArgs = append(cmdArgs, args...)
+	var lines []string
+	for _, elem := range kvs {
+		lines = append(lines, elem.key, elem.val)
+	}
+	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, lines...)
+}
+
+// ctlV3GetCount expects "get --count-only" on the given key to report count.
+func ctlV3GetCount(cx ctlCtx, key string, count int) error {
+	cmdArgs := append(cx.PrefixArgs(), "get", "--count-only", key, "--write-out=fields")
+	return e2e.SpawnWithExpects(cmdArgs, cx.envMap, fmt.Sprintf("\"Count\" : %d", count))
+}
+
 // ctlV3GetWithErr runs "get" command expecting no output but error
 func ctlV3GetWithErr(cx ctlCtx, args []string, errs []string) error {
 	cmdArgs := append(cx.PrefixArgs(), "get")
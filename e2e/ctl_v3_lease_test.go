@@ -15,13 +15,18 @@
 package e2e
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
 	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestCtlV3LeaseGrantTimeToLive(t *testing.T) { testCtl(t, leaseTestGrantTimeToLive) }
@@ -108,6 +113,35 @@ func TestCtlV3LeaseRevokePeerTLS(t *testing.T) {
 	testCtl(t, leaseTestRevoked, withCfg(*e2e.NewConfigPeerTLS()))
 }
 
+func TestCtlV3LeaseAttachSurvivesRestart(t *testing.T) {
+	testCtl(t, leaseTestAttachSurvivesRestart, withQuorum())
+}
+
+func TestCtlV3LeaseTimeToLiveKeys(t *testing.T) { testCtl(t, leaseTestTimeToLiveKeys) }
+func TestCtlV3LeasePutReassign(t *testing.T)    { testCtl(t, leaseTestPutReassign) }
+func TestCtlV3LeaseExpireNoKeepAlive(t *testing.T) {
+	testCtl(t, leaseTestExpireNoKeepAlive)
+}
+func TestCtlV3LeaseDeleteKeyKeepsLease(t *testing.T) {
+	testCtl(t, leaseTestDeleteKeyKeepsLease)
+}
+func TestCtlV3LeaseGrantNonPositiveTTL(t *testing.T) {
+	testCtl(t, leaseTestGrantNonPositiveTTL)
+}
+
+// TestCtlV3LeaseClockStepBackward steps the wall clock of the whole host, so
+// it only runs when opted in with ETCD_E2E_STEP_CLOCK=true.
+func TestCtlV3LeaseClockStepBackward(t *testing.T) {
+	if os.Getenv("ETCD_E2E_STEP_CLOCK") != "true" {
+		t.Skip("stepping the host clock requires ETCD_E2E_STEP_CLOCK=true")
+	}
+	if !e2e.HasCapSysTime() {
+		t.Skip("stepping the clock requires CAP_SYS_TIME")
+	}
+	// auto TLS certificates issued before the step would not be valid yet after it
+	testCtl(t, leaseTestClockStepBackward, withQuorum(), withCfg(*e2e.NewConfigNoTLS()), withTestTimeout(time.Minute))
+}
+
 func leaseTestGrantTimeToLive(cx ctlCtx) {
 	id, err := ctlV3LeaseGrant(cx, 10)
 	if err != nil {
@@ -134,6 +168,185 @@ func leaseTestGrantTimeToLive(cx ctlCtx) {
 	}
 }
 
+func leaseTestTimeToLiveKeys(cx ctlCtx) {
+	const ttl = 100
+	id, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestTimeToLiveKeys: ctlV3LeaseGrant error (%v)", err)
+	}
+	wkeys := []string{"key1", "key2", "key3"}
+	for _, key := range wkeys {
+		if err = ctlV3Put(cx, key, "val", id); err != nil {
+			cx.t.Fatalf("leaseTestTimeToLiveKeys: ctlV3Put error (%v)", err)
+		}
+	}
+
+	remaining, keys, err := ctlV3LeaseTimeToLiveWithKeys(cx, id)
+	if err != nil {
+		cx.t.Fatalf("leaseTestTimeToLiveKeys: ctlV3LeaseTimeToLiveWithKeys error (%v)", err)
+	}
+	if remaining <= 0 || remaining > ttl {
+		cx.t.Fatalf("leaseTestTimeToLiveKeys: expected remaining TTL in (0, %d], got %d", ttl, remaining)
+	}
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, wkeys) {
+		cx.t.Fatalf("leaseTestTimeToLiveKeys: expected attached keys %q, got %q", wkeys, keys)
+	}
+}
+
+// leaseTestPutReassign ensures putting a key attached to one lease with another
+// lease moves the key to the new lease, so revoking the old lease leaves the
+// key in place.
+func leaseTestPutReassign(cx ctlCtx) {
+	const ttl = 100
+	oldID, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3LeaseGrant error (%v)", err)
+	}
+	newID, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3LeaseGrant error (%v)", err)
+	}
+	if err = ctlV3Put(cx, "key", "val1", oldID); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3Put error (%v)", err)
+	}
+	if err = ctlV3Put(cx, "key", "val2", newID); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3Put error (%v)", err)
+	}
+
+	for _, tc := range []struct {
+		id   string
+		keys []string
+	}{
+		{id: oldID},
+		{id: newID, keys: []string{"key"}},
+	} {
+		_, keys, err := ctlV3LeaseTimeToLiveWithKeys(cx, tc.id)
+		if err != nil {
+			cx.t.Fatalf("leaseTestPutReassign: ctlV3LeaseTimeToLiveWithKeys error (%v)", err)
+		}
+		if !reflect.DeepEqual(keys, tc.keys) {
+			cx.t.Fatalf("leaseTestPutReassign: expected lease %s to hold %q, got %q", tc.id, tc.keys, keys)
+		}
+	}
+	lease, err := strconv.ParseInt(newID, 16, 64)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.cfg.ClientTLS, cx.cfg.IsClientAutoTLS)
+	resp, err := cli.Get(context.TODO(), "key")
+	if err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: get error (%v)", err)
+	}
+	if len(resp.Kvs) != 1 || resp.Kvs[0].Lease != lease {
+		cx.t.Fatalf("leaseTestPutReassign: expected key attached to lease %s, got %+v", newID, resp.Kvs)
+	}
+
+	if err = ctlV3LeaseRevoke(cx, oldID); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3LeaseRevoke error (%v)", err)
+	}
+	if err = ctlV3Get(cx, []string{"key"}, kv{"key", "val2"}); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: expected the key to outlive its old lease (%v)", err)
+	}
+	if err = ctlV3LeaseRevoke(cx, newID); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: ctlV3LeaseRevoke error (%v)", err)
+	}
+	if resp, err = cli.Get(context.TODO(), "key"); err != nil {
+		cx.t.Fatalf("leaseTestPutReassign: get error (%v)", err)
+	}
+	if len(resp.Kvs) != 0 {
+		cx.t.Fatalf("leaseTestPutReassign: expected the key deleted with its lease, got %+v", resp.Kvs)
+	}
+}
+
+// leaseTestClockStepBackward ensures leases neither report negative TTLs nor
+// expire early when the wall clock steps backward, since lease and election
+// timers are based on the monotonic clock. Go reads the clock through the
+// vDSO, bypassing fake clock libraries such as libfaketime, so the members
+// cannot get a clock of their own and the host clock is stepped instead. The
+// step is kept to a minute, which still pushes a TTL computed from the wall
+// clock past the lease TTL.
+func leaseTestClockStepBackward(cx ctlCtx) {
+	const ttl = 10
+	id, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestClockStepBackward: ctlV3LeaseGrant error (%v)", err)
+	}
+	// time.Now carries a monotonic reading, unaffected by the clock step
+	granted := time.Now()
+	if err = ctlV3Put(cx, "foo", "bar", id); err != nil {
+		cx.t.Fatalf("leaseTestClockStepBackward: ctlV3Put error (%v)", err)
+	}
+
+	if err = e2e.StepClock(-time.Minute); err != nil {
+		cx.t.Fatal(err)
+	}
+	defer func() {
+		if err := e2e.StepClock(time.Minute); err != nil {
+			cx.t.Error(err)
+		}
+	}()
+
+	for time.Since(granted) < ttl*time.Second/2 {
+		remaining, keys, err := ctlV3LeaseTimeToLiveWithKeys(cx, id)
+		if err != nil {
+			cx.t.Fatalf("leaseTestClockStepBackward: ctlV3LeaseTimeToLiveWithKeys error (%v)", err)
+		}
+		if remaining <= 0 || remaining > ttl {
+			cx.t.Fatalf("leaseTestClockStepBackward: expected remaining TTL in (0, %d] after %v, got %d", ttl, time.Since(granted), remaining)
+		}
+		if len(keys) != 1 || keys[0] != "foo" {
+			cx.t.Fatalf("leaseTestClockStepBackward: expected lease to keep key foo, got %q", keys)
+		}
+		time.Sleep(time.Second)
+	}
+
+	// heartbeats keep the leader in place, so writes still go through
+	if err = ctlV3Put(cx, "bar", "baz", ""); err != nil {
+		cx.t.Fatalf("leaseTestClockStepBackward: ctlV3Put error (%v)", err)
+	}
+
+	// the lease still expires on time rather than a minute late
+	deadline := granted.Add(3 * ttl * time.Second)
+	for {
+		remaining, _, err := ctlV3LeaseTimeToLiveWithKeys(cx, id)
+		if err != nil {
+			cx.t.Fatalf("leaseTestClockStepBackward: ctlV3LeaseTimeToLiveWithKeys error (%v)", err)
+		}
+		if remaining == -1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			cx.t.Fatalf("leaseTestClockStepBackward: expected lease to expire within %v, remaining TTL %d", deadline.Sub(granted), remaining)
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// leaseTestGrantNonPositiveTTL ensures a lease granted with a zero or negative
+// TTL is not rejected but raised to the minimum lease TTL, which is 1.5 times
+// the election timeout rounded up to seconds, so 2s with the default 1s.
+func leaseTestGrantNonPositiveTTL(cx ctlCtx) {
+	const minLeaseTTL = 2
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.cfg.ClientTLS, cx.cfg.IsClientAutoTLS)
+	for _, ttl := range []int64{0, -5} {
+		resp, err := cli.Grant(context.TODO(), ttl)
+		if err != nil {
+			cx.t.Fatalf("lease grant with TTL %d failed (%v)", ttl, err)
+		}
+		if resp.TTL != minLeaseTTL {
+			cx.t.Fatalf("lease granted with TTL %d has TTL %d, expected %d", ttl, resp.TTL, minLeaseTTL)
+		}
+		ttlResp, err := cli.TimeToLive(context.TODO(), resp.ID)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if ttlResp.GrantedTTL != minLeaseTTL {
+			cx.t.Fatalf("lease granted with TTL %d reports granted TTL %d, expected %d", ttl, ttlResp.GrantedTTL, minLeaseTTL)
+		}
+	}
+}
+
 func leaseTestGrantLeaseListed(cx ctlCtx) {
 	err := leaseTestGrantLeasesList(cx)
 	if err != nil {
@@ -188,6 +401,82 @@ func leaseTestTimeToLiveExpire(cx ctlCtx, ttl int) error {
 	return nil
 }
 
+// leaseTestExpireNoKeepAlive ensures a lease that is not kept alive expires
+// around its TTL, deleting the key attached to it.
+func leaseTestExpireNoKeepAlive(cx ctlCtx) {
+	const ttl = 2
+	leaseID, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: ctlV3LeaseGrant error (%v)", err)
+	}
+	granted := time.Now()
+	if err = ctlV3Put(cx, "key", "val", leaseID); err != nil {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: ctlV3Put error (%v)", err)
+	}
+	if err = ctlV3Get(cx, []string{"key"}, kv{"key", "val"}); err != nil {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: ctlV3Get error (%v)", err)
+	}
+
+	if err = waitLeaseExpired(cx, leaseID, 5*ttl*time.Second); err != nil {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: %v", err)
+	}
+	// the server checks for expired leases every 500ms
+	if elapsed := time.Since(granted); elapsed < ttl*time.Second-500*time.Millisecond {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: lease expired after %v, before its %ds TTL", elapsed, ttl)
+	}
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.cfg.ClientTLS, cx.cfg.IsClientAutoTLS)
+	resp, err := cli.Get(context.TODO(), "key")
+	if err != nil {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: get error (%v)", err)
+	}
+	if len(resp.Kvs) != 0 {
+		cx.t.Fatalf("leaseTestExpireNoKeepAlive: expected the key deleted with its lease, got %+v", resp.Kvs)
+	}
+}
+
+// leaseTestDeleteKeyKeepsLease ensures deleting one of the keys of a lease
+// only detaches that key, and the lease keeps the others until it expires.
+func leaseTestDeleteKeyKeepsLease(cx ctlCtx) {
+	const ttl = 3
+	leaseID, err := ctlV3LeaseGrant(cx, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3LeaseGrant error (%v)", err)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if err = ctlV3Put(cx, key, "val", leaseID); err != nil {
+			cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3Put error (%v)", err)
+		}
+	}
+	if err = ctlV3Del(cx, []string{"key1"}, 1); err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3Del error (%v)", err)
+	}
+
+	remaining, keys, err := ctlV3LeaseTimeToLiveWithKeys(cx, leaseID)
+	if err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3LeaseTimeToLiveWithKeys error (%v)", err)
+	}
+	if remaining <= 0 {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: expected the lease to live on, got TTL %d", remaining)
+	}
+	if len(keys) != 1 || keys[0] != "key2" {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: expected only key2 attached, got %v", keys)
+	}
+	if err = ctlV3Get(cx, []string{"key2"}, kv{"key2", "val"}); err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3Get error (%v)", err)
+	}
+
+	if err = waitLeaseExpired(cx, leaseID, 5*ttl*time.Second); err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: %v", err)
+	}
+	resp, err := ctlV3GetJSON(cx, "key", "--prefix", "--count-only")
+	if err != nil {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: ctlV3GetJSON error (%v)", err)
+	}
+	if resp.Count != 0 {
+		cx.t.Fatalf("leaseTestDeleteKeyKeepsLease: expected no keys after the lease expired, got %d", resp.Count)
+	}
+}
+
 func leaseTestKeepAlive(cx ctlCtx) {
 	// put with TTL 10 seconds and keep-alive
 	leaseID, err := ctlV3LeaseGrant(cx, 10)
@@ -247,8 +536,55 @@ func leaseTestRevoke(cx ctlCtx) error {
 	return nil
 }
 
+// leaseTestAttachSurvivesRestart grants a lease and attaches a key through
+// one member, restarts that member and checks that both the lease and the
+// attached key are still there with a sane remaining TTL.
+func leaseTestAttachSurvivesRestart(cx ctlCtx) {
+	ttl := 60
+	proc := cx.epc.Procs[0]
+	eps := proc.EndpointsV3()
+
+	leaseID, err := ctlV3LeaseGrantWithEndpoints(cx, eps, ttl)
+	if err != nil {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: ctlV3LeaseGrant error (%v)", err)
+	}
+	granted := time.Now()
+	putArgs := append(cx.prefixArgs(eps), "put", "key", "val", "--lease", leaseID)
+	if err = e2e.SpawnWithExpectWithEnv(putArgs, cx.envMap, "OK"); err != nil {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: put error (%v)", err)
+	}
+
+	if err = proc.Restart(); err != nil {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: restart error (%v)", err)
+	}
+
+	resp, err := ctlV3LeaseTimeToLiveWithEndpoints(cx, eps, leaseID)
+	if err != nil {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: ctlV3LeaseTimeToLive error (%v)", err)
+	}
+	if resp.GrantedTTL != int64(ttl) {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: expected granted TTL %d, got %d", ttl, resp.GrantedTTL)
+	}
+	// a leader change during the restart may renew the lease, so the remaining
+	// TTL can only be bounded from below by the time elapsed since the grant
+	elapsed := int64(time.Since(granted)/time.Second) + 1
+	if resp.TTL <= 0 || resp.TTL > int64(ttl) || resp.TTL < int64(ttl)-elapsed {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: unexpected remaining TTL %d (granted %d, elapsed %ds)", resp.TTL, ttl, elapsed)
+	}
+	if len(resp.Keys) != 1 || string(resp.Keys[0]) != "key" {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: expected attached keys [key], got %q", resp.Keys)
+	}
+	if err = ctlV3GetFromEndpoint(cx, eps[0], "l", []string{"key"}, kv{"key", "val"}); err != nil {
+		cx.t.Fatalf("leaseTestAttachSurvivesRestart: ctlV3Get error (%v)", err)
+	}
+}
+
 func ctlV3LeaseGrant(cx ctlCtx, ttl int) (string, error) {
-	cmdArgs := append(cx.PrefixArgs(), "lease", "grant", strconv.Itoa(ttl))
+	return ctlV3LeaseGrantWithEndpoints(cx, cx.epc.EndpointsV3(), ttl)
+}
+
+func ctlV3LeaseGrantWithEndpoints(cx ctlCtx, eps []string, ttl int) (string, error) {
+	cmdArgs := append(cx.prefixArgs(eps), "lease", "grant", strconv.Itoa(ttl))
 	proc, err := e2e.SpawnCmd(cmdArgs, cx.envMap)
 	if err != nil {
 		return "", err
@@ -270,6 +606,23 @@ func ctlV3LeaseGrant(cx ctlCtx, ttl int) (string, error) {
 	return hs[1], nil
 }
 
+// waitLeaseExpired polls "lease timetolive" until the lease is gone, or fails
+// after timeout. Expired leases are revoked, with their keys, a little after
+// their TTL runs out, so the lease may outlive its TTL briefly.
+func waitLeaseExpired(cx ctlCtx, leaseID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := ctlV3LeaseTimeToLiveWithEndpoints(cx, cx.epc.EndpointsV3(), leaseID)
+		if err == nil && resp.TTL == -1 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("lease %s not expired within %v (ttl %d, %v)", leaseID, timeout, resp.TTL, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
 func ctlV3LeaseKeepAlive(cx ctlCtx, leaseID string) error {
 	cmdArgs := append(cx.PrefixArgs(), "lease", "keep-alive", leaseID)
 
@@ -310,3 +663,46 @@ func ctlV3LeaseTimeToLive(cx ctlCtx, leaseID string, withKeys bool) error {
 	}
 	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, fmt.Sprintf("lease %s granted with", leaseID))
 }
+
+// leaseTimeToLiveResponse mirrors the JSON output of "lease timetolive".
+type leaseTimeToLiveResponse struct {
+	ID         int64    `json:"id"`
+	TTL        int64    `json:"ttl"`
+	GrantedTTL int64    `json:"granted-ttl"`
+	Keys       [][]byte `json:"keys"`
+}
+
+// ctlV3LeaseTimeToLiveWithKeys returns the remaining TTL of the lease, in
+// seconds, along with the keys attached to it.
+func ctlV3LeaseTimeToLiveWithKeys(cx ctlCtx, leaseID string) (remaining int, keys []string, err error) {
+	resp, err := ctlV3LeaseTimeToLiveWithEndpoints(cx, cx.epc.EndpointsV3(), leaseID)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, k := range resp.Keys {
+		keys = append(keys, string(k))
+	}
+	return int(resp.TTL), keys, nil
+}
+
+func ctlV3LeaseTimeToLiveWithEndpoints(cx ctlCtx, eps []string, leaseID string) (leaseTimeToLiveResponse, error) {
+	cmdArgs := append(cx.prefixArgs(eps), "--write-out", "json", "lease", "timetolive", leaseID, "--keys")
+	proc, err := e2e.SpawnCmd(cmdArgs, cx.envMap)
+	if err != nil {
+		return leaseTimeToLiveResponse{}, err
+	}
+	var txt string
+	txt, err = proc.Expect("granted-ttl")
+	if err != nil {
+		return leaseTimeToLiveResponse{}, err
+	}
+	if err = proc.Close(); err != nil {
+		return leaseTimeToLiveResponse{}, err
+	}
+	resp := leaseTimeToLiveResponse{}
+	dec := json.NewDecoder(strings.NewReader(txt))
+	if err := dec.Decode(&resp); err != nil {
+		return leaseTimeToLiveResponse{}, err
+	}
+	return resp, nil
+}
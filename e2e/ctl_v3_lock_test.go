@@ -21,8 +21,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/pkg/v3/expect"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3Lock(t *testing.T) {
@@ -18,15 +18,19 @@ package e2e
 
 import (
 	"context"
+	"fmt"
 	"math/rand"
+	"net/url"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/server/v3/etcdserver"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestMemberReplace(t *testing.T) {
@@ -96,3 +100,190 @@ func TestMemberReplace(t *testing.T) {
 		}
 	})
 }
+
+func TestMemberReplaceUnderLoad(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 3,
+	})
+	require.NoError(t, err)
+	defer epc.Close()
+
+	cli := newClient(t, epc.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	stopc := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		acked    []string
+		writeErr int
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stopc:
+				return
+			default:
+			}
+			key := fmt.Sprintf("key-%06d", i)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, perr := cli.Put(ctx, key, "v")
+			cancel()
+			mu.Lock()
+			if perr == nil {
+				acked = append(acked, key)
+			} else {
+				writeErr++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	leadIdx := epc.WaitLeader(t)
+	followerIdx := (leadIdx + 1) % len(epc.Procs)
+	newPeerURL := fmt.Sprintf("%s://localhost:%d", epc.Cfg.PeerScheme(), e2e.EtcdProcessBasePort+5*len(epc.Procs)+1)
+	require.NoError(t, ReplaceMember(epc, followerIdx, "replacement", newPeerURL))
+
+	close(stopc)
+	wg.Wait()
+	t.Logf("%d writes acknowledged, %d failed during replacement", len(acked), writeErr)
+	require.NotEmpty(t, acked)
+
+	cc := e2e.NewEtcdctl(epc.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false)
+	_, found, err := getMemberIdByName(context.TODO(), cc, "replacement")
+	require.NoError(t, err)
+	require.True(t, found, "replacement member not found")
+
+	newCli := newClient(t, epc.Procs[followerIdx].EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	for _, key := range acked {
+		resp, gerr := newCli.Get(context.TODO(), key)
+		require.NoError(t, gerr)
+		require.Equalf(t, int64(1), resp.Count, "acknowledged write %q lost", key)
+	}
+}
+
+// ReplaceMember replaces the member at idx with a new member named newName
+// advertising newPeerURL. The old member is removed before the new one is
+// added, so a 3-member cluster never needs more than 2 live voters for quorum.
+// The new member reuses the client URL and data directory of the old one and
+// ReplaceMember returns once it has caught up with the leader.
+func ReplaceMember(epc *e2e.EtcdProcessCluster, idx int, newName, newPeerURL string) error {
+	old := epc.Procs[idx]
+	purl, err := url.Parse(newPeerURL)
+	if err != nil {
+		return err
+	}
+	var endpoints []string
+	for i, p := range epc.Procs {
+		if i != idx {
+			endpoints = append(endpoints, p.EndpointsV3()...)
+		}
+	}
+	cc := e2e.NewEtcdctl(endpoints, epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false)
+
+	oldID, found, err := getMemberIdByName(context.TODO(), cc, old.Config().Name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("member %q not found", old.Config().Name)
+	}
+	// Need to wait health interval for cluster to accept member changes
+	time.Sleep(etcdserver.HealthInterval)
+	if _, err = cc.MemberRemove(oldID); err != nil {
+		return fmt.Errorf("failed to remove member %q (%v)", old.Config().Name, err)
+	}
+	for old.IsRunning() {
+		old.Close()
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err = os.RemoveAll(old.Config().DataDirPath); err != nil {
+		return err
+	}
+
+	resp, err := cc.MemberAdd(newName, []string{newPeerURL})
+	if err != nil {
+		return fmt.Errorf("failed to add member %q (%v)", newName, err)
+	}
+	var initialCluster []string
+	for _, m := range resp.Members {
+		name := m.Name
+		if m.ID == resp.Member.ID {
+			name = newName
+		}
+		for _, u := range m.PeerURLs {
+			initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", name, u))
+		}
+	}
+
+	cfg := *old.Config()
+	cfg.Name = newName
+	cfg.Purl = *purl
+	cfg.InitialCluster = strings.Join(initialCluster, ",")
+	cfg.Args = append([]string{}, cfg.Args...)
+	cfg.Args = setFlag(cfg.Args, "--name", newName)
+	cfg.Args = setFlag(cfg.Args, "--listen-peer-urls", newPeerURL)
+	cfg.Args = setFlag(cfg.Args, "--initial-advertise-peer-urls", newPeerURL)
+	cfg.Args = setFlag(cfg.Args, "--initial-cluster", cfg.InitialCluster)
+	cfg.Args = setFlag(cfg.Args, "--initial-cluster-state", "existing")
+
+	proc, err := e2e.NewEtcdProcess(&cfg)
+	if err != nil {
+		return err
+	}
+	epc.Procs[idx] = proc
+	if err = proc.Start(); err != nil {
+		return err
+	}
+	return waitMemberSynced(epc, idx)
+}
+
+// waitMemberSynced waits until the member at idx has applied everything the
+// leader had committed when the wait started.
+func waitMemberSynced(epc *e2e.EtcdProcessCluster, idx int) error {
+	status := func(p e2e.EtcdProcess) (uint64, uint64, error) {
+		resp, err := p.Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).Status()
+		if err != nil || len(resp) == 0 {
+			return 0, 0, fmt.Errorf("failed to get status of %q (%v)", p.Config().Name, err)
+		}
+		return resp[0].RaftIndex, resp[0].RaftAppliedIndex, nil
+	}
+	var leaderIndex uint64
+	for i, p := range epc.Procs {
+		if i == idx {
+			continue
+		}
+		index, _, err := status(p)
+		if err != nil {
+			return err
+		}
+		if index > leaderIndex {
+			leaderIndex = index
+		}
+	}
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		_, applied, err := status(epc.Procs[idx])
+		if err == nil && applied >= leaderIndex {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("member %q did not catch up with index %d (applied %d, %v)", epc.Procs[idx].Config().Name, leaderIndex, applied, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// setFlag sets the value of a "--flag value" pair in args, appending the
+// pair if the flag is not present.
+func setFlag(args []string, flag, value string) []string {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			args[i+1] = value
+			return args
+		}
+	}
+	return append(args, flag, value)
+}
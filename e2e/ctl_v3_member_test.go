@@ -15,19 +15,25 @@
 package e2e
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"go.etcd.io/etcd/server/v3/etcdserver"
 )
 
 func TestCtlV3MemberList(t *testing.T)        { testCtl(t, memberListTest) }
 func TestCtlV3MemberListWithHex(t *testing.T) { testCtl(t, memberListWithHexTest) }
+func TestCtlV3MemberListFiveMembers(t *testing.T) {
+	testCtl(t, memberListFiveMembersTest, withClusterSize(5))
+}
 func TestCtlV3MemberListNoTLS(t *testing.T) {
 	testCtl(t, memberListTest, withCfg(*e2e.NewConfigNoTLS()))
 }
@@ -87,6 +93,42 @@ func TestCtlV3MemberUpdateClientAutoTLS(t *testing.T) {
 func TestCtlV3MemberUpdatePeerTLS(t *testing.T) {
 	testCtl(t, memberUpdateTest, withCfg(*e2e.NewConfigPeerTLS()))
 }
+func TestCtlV3MemberAddThenListFromNewMember(t *testing.T) {
+	testCtl(t, memberAddThenListFromNewMemberTest, withQuorum())
+}
+func TestCtlV3MemberRemoveLearner(t *testing.T) {
+	testCtl(t, memberRemoveLearnerTest, withQuorum())
+}
+func TestCtlV3MemberPromoteLearnerInPlace(t *testing.T) {
+	testCtl(t, memberPromoteLearnerInPlaceTest, withQuorum())
+}
+func TestCtlV3MemberLearnerServesAfterPromotion(t *testing.T) {
+	testCtl(t, memberLearnerServesAfterPromotionTest, withQuorum())
+}
+func TestCtlV3MemberAddDuplicatePeerURL(t *testing.T) {
+	testCtl(t, memberAddDuplicatePeerURLTest, withQuorum())
+}
+func TestCtlV3MemberAddTwice(t *testing.T) {
+	testCtl(t, memberAddTwiceTest, withQuorum())
+}
+func TestCtlV3MemberAddTwiceNoStrictReconfig(t *testing.T) {
+	testCtl(t, memberAddTwiceTest, withQuorum(), withNoStrictReconfig())
+}
+func TestCtlV3MemberForceNewCluster(t *testing.T) {
+	testCtl(t, memberForceNewClusterTest, withQuorum())
+}
+func TestCtlV3MemberIDStableAcrossRestart(t *testing.T) {
+	testCtl(t, memberIDStableAcrossRestartTest, withQuorum())
+}
+func TestCtlV3MemberAddUnreachable(t *testing.T) {
+	testCtl(t, memberAddUnreachableTest, withQuorum())
+}
+func TestCtlV3MemberAddUnreachableNoStrictReconfig(t *testing.T) {
+	testCtl(t, memberAddUnreachableTest, withQuorum(), withNoStrictReconfig())
+}
+func TestCtlV3MemberRemoveRejectsRemovedPeer(t *testing.T) {
+	testCtl(t, memberRemoveRejectsRemovedPeerTest, withQuorum(), withCfg(e2e.EtcdProcessClusterConfig{ClusterSize: 3, IsPeerTLS: true, PeerProxy: true}))
+}
 
 func memberListTest(cx ctlCtx) {
 	if err := ctlV3MemberList(cx); err != nil {
@@ -94,6 +136,16 @@ func memberListTest(cx ctlCtx) {
 	}
 }
 
+func memberListFiveMembersTest(cx ctlCtx) {
+	resp, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Members) != 5 {
+		cx.t.Fatalf("expected 5 members, got %d", len(resp.Members))
+	}
+}
+
 func ctlV3MemberList(cx ctlCtx) error {
 	cmdArgs := append(cx.PrefixArgs(), "member", "list")
 	lines := make([]string, cx.cfg.ClusterSize)
@@ -104,7 +156,11 @@ func ctlV3MemberList(cx ctlCtx) error {
 }
 
 func getMemberList(cx ctlCtx) (etcdserverpb.MemberListResponse, error) {
-	cmdArgs := append(cx.PrefixArgs(), "--write-out", "json", "member", "list")
+	return getMemberListWithEndpoints(cx, cx.epc.EndpointsV3())
+}
+
+func getMemberListWithEndpoints(cx ctlCtx, eps []string) (etcdserverpb.MemberListResponse, error) {
+	cmdArgs := append(cx.prefixArgs(eps), "--write-out", "json", "member", "list")
 
 	proc, err := e2e.SpawnCmd(cmdArgs, cx.envMap)
 	if err != nil {
@@ -127,6 +183,10 @@ func getMemberList(cx ctlCtx) (etcdserverpb.MemberListResponse, error) {
 	return resp, nil
 }
 
+func getMemberListJSON(cx ctlCtx) (etcdserverpb.MemberListResponse, error) {
+	return runJSONCmd[etcdserverpb.MemberListResponse](cx, "member", "list")
+}
+
 func memberListWithHexTest(cx ctlCtx) {
 	resp, err := getMemberList(cx)
 	if err != nil {
@@ -202,6 +262,463 @@ func memberAddForLearnerTest(cx ctlCtx) {
 	}
 }
 
+// memberRemoveLearnerTest adds a learner and removes it again, ensuring the
+// voting membership and quorum are unaffected throughout.
+func memberRemoveLearnerTest(cx ctlCtx) {
+	mcfg := cx.epc.NewMemberConfig(cx.t)
+	if err := ctlV3MemberAdd(cx, mcfg.Purl.String(), true); err != nil {
+		cx.t.Fatal(err)
+	}
+	learner, err := cx.epc.StartNewProc(mcfg)
+	if err != nil {
+		cx.t.Fatalf("failed to start learner (%v)", err)
+	}
+	resp, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	var learnerID uint64
+	voters := 0
+	for _, m := range resp.Members {
+		if m.IsLearner {
+			learnerID = m.ID
+		} else {
+			voters++
+		}
+	}
+	if learnerID == 0 || voters != 3 {
+		cx.t.Fatalf("expected 3 voting members and 1 learner, got %+v", resp.Members)
+	}
+	if err = ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatalf("put with learner in the cluster failed (%v)", err)
+	}
+
+	// a learner does not count toward quorum, so its absence must not matter
+	if err = learner.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = ctlV3Put(cx, "foo", "bar2", ""); err != nil {
+		cx.t.Fatalf("put with learner stopped failed (%v)", err)
+	}
+
+	ep := cx.epc.Procs[0].EndpointsV3()[0]
+	clusterID := fmt.Sprintf("%x", resp.Header.ClusterId)
+	if err = ctlV3MemberRemove(cx, ep, fmt.Sprintf("%x", learnerID), clusterID); err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = cx.epc.RemoveProc(learner); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	resp, err = getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Members) != 3 {
+		cx.t.Fatalf("expected 3 members after removing the learner, got %d", len(resp.Members))
+	}
+	for _, m := range resp.Members {
+		if m.IsLearner || m.ID == learnerID {
+			cx.t.Fatalf("unexpected member %+v after removing the learner", m)
+		}
+	}
+	if err = ctlV3Put(cx, "foo", "bar3", ""); err != nil {
+		cx.t.Fatalf("put after removing the learner failed (%v)", err)
+	}
+	if err = ctlV3Get(cx, []string{"foo"}, kv{"foo", "bar3"}); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
+// memberPromoteLearnerInPlaceTest promotes a running learner and ensures the
+// member list flips its learner flag under the same member ID, while the
+// process that joined as a learner keeps running and serving.
+func memberPromoteLearnerInPlaceTest(cx ctlCtx) {
+	mcfg := cx.epc.NewMemberConfig(cx.t)
+	if err := ctlV3MemberAdd(cx, mcfg.Purl.String(), true); err != nil {
+		cx.t.Fatal(err)
+	}
+	learner, err := cx.epc.StartNewProc(mcfg)
+	if err != nil {
+		cx.t.Fatalf("failed to start learner (%v)", err)
+	}
+
+	// a learner rejects membership requests, so they go to a voting member
+	ep := cx.epc.Procs[0].EndpointsV3()[0]
+	learnerID := memberIDByPeerURL(cx, ep, mcfg.Purl.String(), true)
+	if err = ctlV3MemberPromoteWhenSynced(cx, ep, fmt.Sprintf("%x", learnerID), 10*time.Second); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if id := memberIDByPeerURL(cx, ep, mcfg.Purl.String(), false); id != learnerID {
+		cx.t.Fatalf("expected the promoted member to keep ID %x, got %x", learnerID, id)
+	}
+	if !learner.IsRunning() {
+		cx.t.Fatal("expected the promoted member to keep running")
+	}
+	lcx := cx
+	lcx.epc = &e2e.EtcdProcessCluster{Cfg: cx.epc.Cfg, Procs: []e2e.EtcdProcess{learner}}
+	if err = ctlV3Put(lcx, "foo", "bar", ""); err != nil {
+		cx.t.Fatalf("put through the promoted member failed (%v)", err)
+	}
+	if err = ctlV3Get(lcx, []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatalf("get from the promoted member failed (%v)", err)
+	}
+}
+
+// memberLearnerServesAfterPromotionTest ensures a learner is listed as such
+// and rejects linearizable reads until it is promoted, then serves them.
+func memberLearnerServesAfterPromotionTest(cx ctlCtx) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	voter := cx.epc.Procs[0].EndpointsV3()[0]
+	learner, err := cx.epc.AddLearner(ctx, cx.t)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	learnerID := memberIDByPeerURL(cx, voter, learner.Config().Purl.String(), true)
+
+	// the learner is one of the cluster endpoints now, write through a voter
+	if err = cx.epc.Procs[0].Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false).Put("foo", "bar"); err != nil {
+		cx.t.Fatal(err)
+	}
+	cmdArgs := append(cx.prefixArgs(learner.EndpointsV3()), "get", "foo")
+	if err = e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "rpc not supported for learner"); err != nil {
+		cx.t.Fatalf("expected the learner to reject a linearizable read (%v)", err)
+	}
+
+	if err = cx.epc.PromoteLearner(ctx, learnerID); err != nil {
+		cx.t.Fatal(err)
+	}
+	memberIDByPeerURL(cx, voter, learner.Config().Purl.String(), false)
+	resp, err := learner.Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false).Get("foo")
+	if err != nil {
+		cx.t.Fatalf("expected the promoted member to serve a linearizable read (%v)", err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "bar" {
+		cx.t.Fatalf("expected foo=bar from the promoted member, got %+v", resp.Kvs)
+	}
+}
+
+// memberForceNewClusterTest loses quorum for good, then restarts the only
+// surviving member with --force-new-cluster and ensures it forms a single
+// member cluster that keeps its ID and data, and accepts new members.
+func memberForceNewClusterTest(cx ctlCtx) {
+	if err := ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	survivor := cx.epc.Procs[0]
+	survivorID := memberIDByPeerURL(cx, survivor.EndpointsV3()[0], survivor.Config().Purl.String(), false)
+
+	// the other members and their data are lost
+	for _, proc := range cx.epc.Procs[1:] {
+		if err := cx.epc.RemoveProc(proc); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	if err := survivor.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+	survivor.Config().Args = e2e.PatchArgs(survivor.Config().Args, "force-new-cluster", "true")
+	if err := survivor.Start(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	resp, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Members) != 1 || resp.Members[0].ID != survivorID {
+		cx.t.Fatalf("expected member %x to be the only member, got %+v", survivorID, resp.Members)
+	}
+	if err = ctlV3Get(cx, []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	// the flag is only meant for the recovery, later restarts run without it
+	survivor.Config().Args = e2e.PatchArgs(survivor.Config().Args, "force-new-cluster", "false")
+	if err = survivor.Restart(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	learner, err := cx.epc.AddLearner(ctx, cx.t)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	learnerID := memberIDByPeerURL(cx, survivor.EndpointsV3()[0], learner.Config().Purl.String(), true)
+	if err = cx.epc.PromoteLearner(ctx, learnerID); err != nil {
+		cx.t.Fatal(err)
+	}
+	if resp, err = getMemberList(cx); err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Members) != 2 {
+		cx.t.Fatalf("expected 2 members after re-adding one, got %+v", resp.Members)
+	}
+	if err = ctlV3GetFromEndpoint(cx, learner.EndpointsV3()[0], "l", []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatalf("get from the re-added member failed (%v)", err)
+	}
+}
+
+// memberIDByPeerURL returns the ID of the member advertising peerURL, as
+// listed by ep, failing the test unless it exists with the given learner flag.
+func memberIDByPeerURL(cx ctlCtx, ep, peerURL string, isLearner bool) uint64 {
+	resp, err := getMemberListWithEndpoints(cx, []string{ep})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	for _, m := range resp.Members {
+		for _, u := range m.PeerURLs {
+			if u != peerURL {
+				continue
+			}
+			if m.IsLearner != isLearner {
+				cx.t.Fatalf("expected member %x learner flag to be %v, got %v", m.ID, isLearner, m.IsLearner)
+			}
+			return m.ID
+		}
+	}
+	cx.t.Fatalf("no member with peer URL %s in %+v", peerURL, resp.Members)
+	return 0
+}
+
+// memberAddThenListFromNewMemberTest ensures that a freshly joined member
+// reports the complete membership, including itself, as soon as it serves.
+func memberAddThenListFromNewMemberTest(cx ctlCtx) {
+	before, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	mcfg := cx.epc.NewMemberConfig(cx.t)
+	if err = ctlV3MemberAdd(cx, mcfg.Purl.String(), false); err != nil {
+		cx.t.Fatal(err)
+	}
+	proc, err := cx.epc.StartNewProc(mcfg)
+	if err != nil {
+		cx.t.Fatalf("failed to start new member (%v)", err)
+	}
+
+	resp, err := getMemberListWithEndpoints(cx, proc.EndpointsV3())
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Members) != len(before.Members)+1 {
+		cx.t.Fatalf("expected %d members, got %d", len(before.Members)+1, len(resp.Members))
+	}
+
+	ids := make(map[uint64]*etcdserverpb.Member)
+	for _, m := range resp.Members {
+		ids[m.ID] = m
+	}
+	for _, m := range before.Members {
+		got, ok := ids[m.ID]
+		if !ok {
+			cx.t.Fatalf("member %x missing from new member's member list", m.ID)
+		}
+		if !reflect.DeepEqual(got.PeerURLs, m.PeerURLs) {
+			cx.t.Fatalf("member %x: expected peer URLs %v, got %v", m.ID, m.PeerURLs, got.PeerURLs)
+		}
+	}
+	self, ok := ids[resp.Header.MemberId]
+	if !ok {
+		cx.t.Fatalf("new member %x missing from its own member list", resp.Header.MemberId)
+	}
+	if self.Name != mcfg.Name || !reflect.DeepEqual(self.PeerURLs, []string{mcfg.Purl.String()}) {
+		cx.t.Fatalf("unexpected new member entry %+v", self)
+	}
+}
+
+// memberAddDuplicatePeerURLTest ensures adding a member that advertises the
+// peer URL of an existing member is rejected and leaves membership untouched.
+func memberAddDuplicatePeerURLTest(cx ctlCtx) {
+	before, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	peerURL := before.Members[0].PeerURLs[0]
+	cmdArgs := append(cx.PrefixArgs(), "member", "add", "newmember", fmt.Sprintf("--peer-urls=%s", peerURL))
+	if err = e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "peerURL exists"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	after, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(after.Members) != len(before.Members) {
+		cx.t.Fatalf("expected %d members after rejected add, got %d", len(before.Members), len(after.Members))
+	}
+}
+
+// memberAddTwiceTest repeats a member add with the same peer URL, as a client
+// retrying a timed out request would, and ensures the retry is rejected
+// without registering a second member. With the strict reconfig check the
+// member is added as a learner, since the check rejects any voting member add
+// while the first added member is not connected.
+func memberAddTwiceTest(cx ctlCtx) {
+	// members need to be connected for a health interval before the strict
+	// reconfig check considers the cluster healthy
+	time.Sleep(etcdserver.HealthInterval)
+
+	before, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	isLearner := !cx.noStrictReconfig
+	peerURL := fmt.Sprintf("http://localhost:%d", e2e.EtcdProcessBasePort+11)
+	if err = ctlV3MemberAdd(cx, peerURL, isLearner); err != nil {
+		cx.t.Fatal(err)
+	}
+	cmdArgs := append(cx.PrefixArgs(), "member", "add", "newmember", fmt.Sprintf("--peer-urls=%s", peerURL))
+	if isLearner {
+		cmdArgs = append(cmdArgs, "--learner")
+	}
+	if err = e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "peerURL exists"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	after, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(after.Members) != len(before.Members)+1 {
+		cx.t.Fatalf("expected %d members after the repeated add, got %d", len(before.Members)+1, len(after.Members))
+	}
+	added := 0
+	for _, m := range after.Members {
+		for _, u := range m.PeerURLs {
+			if u == peerURL {
+				added++
+				if m.IsLearner != isLearner {
+					cx.t.Fatalf("expected member %x learner %v, got %v", m.ID, isLearner, m.IsLearner)
+				}
+			}
+		}
+	}
+	if added != 1 {
+		cx.t.Fatalf("expected one member with peer URL %s, got %d", peerURL, added)
+	}
+}
+
+// memberAddUnreachableTest adds two members whose peer URLs can't be reached.
+// The strict reconfig check does not probe the peer URL of the member being
+// added, but requires every existing member to be connected, so the first add
+// is accepted and the second one is rejected as the first member never
+// connects. Both are accepted without the strict reconfig check.
+func memberAddUnreachableTest(cx ctlCtx) {
+	// members need to be connected for a health interval before the strict
+	// reconfig check considers the cluster healthy
+	time.Sleep(etcdserver.HealthInterval)
+
+	if err := ctlV3MemberAdd(cx, fmt.Sprintf("http://localhost:%d", e2e.EtcdProcessBasePort+21), false); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	expected := "unhealthy cluster"
+	if cx.noStrictReconfig {
+		expected = " added to cluster "
+	}
+	cmdArgs := append(cx.PrefixArgs(), "member", "add", "newmember2", fmt.Sprintf("--peer-urls=http://localhost:%d", e2e.EtcdProcessBasePort+22))
+	if err := e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, expected); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
+// memberIDStableAcrossRestartTest ensures every member keeps its ID across a
+// rolling restart, since IDs come from persisted cluster state.
+func memberIDStableAcrossRestartTest(cx ctlCtx) {
+	before := memberIDsByName(cx)
+	if err := cx.epc.RollingRestart(); err != nil {
+		cx.t.Fatal(err)
+	}
+	after := memberIDsByName(cx)
+	if !reflect.DeepEqual(before, after) {
+		cx.t.Fatalf("member IDs changed across restart, before %v, after %v", before, after)
+	}
+	for _, proc := range cx.epc.Procs {
+		resp, err := proc.Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false).Status()
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if id := resp[0].Header.MemberId; id != before[proc.Config().Name] {
+			cx.t.Fatalf("member %s reports ID %x, expected %x", proc.Config().Name, id, before[proc.Config().Name])
+		}
+	}
+}
+
+// memberIDsByName returns the IDs of the cluster members keyed by member name.
+func memberIDsByName(cx ctlCtx) map[string]uint64 {
+	resp, err := getMemberList(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	ids := make(map[string]uint64, len(resp.Members))
+	for _, m := range resp.Members {
+		ids[m.Name] = m.ID
+	}
+	return ids
+}
+
+// memberRemoveRejectsRemovedPeerTest removes a member while its peer traffic is
+// blackholed, so the member is still running and unaware of its removal once
+// it reconnects. The remaining members must reject its raft traffic right away,
+// leaving their term and leader untouched, and the removed member shuts down.
+func memberRemoveRejectsRemovedPeerTest(cx ctlCtx) {
+	removed := cx.epc.Procs[0]
+	removedID := memberIDsByName(cx)[removed.Config().Name]
+	ctl := cx.epc.Procs[1].Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false)
+
+	proxy := removed.PeerProxy()
+	proxy.BlackholeTx()
+	proxy.BlackholeRx()
+
+	// the remaining members keep quorum, electing a new leader if needed
+	if err := ctl.Put("foo", "bar"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if _, err := ctl.MemberRemove(removedID); err != nil {
+		cx.t.Fatal(err)
+	}
+	before, err := ctl.Status()
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	proxy.UnblackholeTx()
+	proxy.UnblackholeRx()
+	if _, err = cx.epc.Procs[1].Logs().Expect("rejected stream from remote peer because it was removed"); err != nil {
+		cx.t.Fatalf("removed member's raft traffic was not rejected (%v)", err)
+	}
+
+	after, err := ctl.Status()
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if after[0].RaftTerm != before[0].RaftTerm || after[0].Leader != before[0].Leader {
+		cx.t.Fatalf("removed member disturbed the cluster, term %d leader %x before, term %d leader %x after",
+			before[0].RaftTerm, before[0].Leader, after[0].RaftTerm, after[0].Leader)
+	}
+	if err = ctl.Put("foo", "baz"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	// the removed member stops itself shortly after learning of its removal
+	for i := 0; removed.IsRunning(); i++ {
+		if i == 50 {
+			cx.t.Fatal("removed member is still running")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	// Stop skips an exited process, so its peer proxy has to be closed here
+	if err = proxy.Close(); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
 func ctlV3MemberAdd(cx ctlCtx, peerURL string, isLearner bool) error {
 	cmdArgs := append(cx.PrefixArgs(), "member", "add", "newmember", fmt.Sprintf("--peer-urls=%s", peerURL))
 	if isLearner {
@@ -210,6 +727,24 @@ func ctlV3MemberAdd(cx ctlCtx, peerURL string, isLearner bool) error {
 	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, " added to cluster ")
 }
 
+func ctlV3MemberPromote(cx ctlCtx, ep, memberID string) error {
+	cmdArgs := append(cx.prefixArgs([]string{ep}), "member", "promote", memberID)
+	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, " promoted in cluster ")
+}
+
+// ctlV3MemberPromoteWhenSynced retries promoting the learner memberID until
+// it has caught up with the leader, or timeout elapses.
+func ctlV3MemberPromoteWhenSynced(cx ctlCtx, ep, memberID string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := ctlV3MemberPromote(cx, ep, memberID)
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
 func memberUpdateTest(cx ctlCtx) {
 	mr, err := getMemberList(cx)
 	if err != nil {
@@ -19,13 +19,14 @@ import (
 	"crypto/tls"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	"go.etcd.io/etcd/client/pkg/v3/types"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3MoveLeaderScenarios(t *testing.T) {
@@ -139,3 +140,245 @@ func testCtlV3MoveLeader(t *testing.T, cfg e2e.EtcdProcessClusterConfig, envVars
 		}
 	}
 }
+
+func TestCtlV3LeaderChurn(t *testing.T) {
+	testCtl(t, func(cx ctlCtx) { RunLeaderChurn(cx, 10) }, withQuorum())
+}
+
+// RunLeaderChurn transfers leadership rounds times in quick succession while a
+// writer keeps committing sequential keys. It ensures every write eventually
+// commits and all members end up with the same data.
+func RunLeaderChurn(cx ctlCtx, rounds int) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	const maxPutRetries = 5
+	stopc := make(chan struct{})
+	donec := make(chan int)
+	var werr error // set by the writer before it reports on donec
+	go func() {
+		written := 0
+		defer func() { donec <- written }()
+		for {
+			select {
+			case <-stopc:
+				return
+			default:
+			}
+			key := fmt.Sprintf("churn-%06d", written)
+			// retry until the write commits, a leader change may fail it
+			// transiently, but give up on a cluster that stays unavailable
+			for retries := 0; ; retries++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				_, err := cli.Put(ctx, key, "v")
+				cancel()
+				if err == nil {
+					break
+				}
+				if retries == maxPutRetries {
+					werr = fmt.Errorf("put %q failed %d times (%v)", key, retries+1, err)
+					return
+				}
+				cx.t.Logf("RunLeaderChurn: put %q failed (%v), retrying", key, err)
+			}
+			written++
+		}
+	}()
+
+	for i := 0; i < rounds; i++ {
+		if err := moveLeaderToFollower(cx); err != nil {
+			close(stopc)
+			<-donec
+			cx.t.Fatalf("RunLeaderChurn: round %d: %v", i, err)
+		}
+	}
+	close(stopc)
+	written := <-donec
+	if werr != nil {
+		cx.t.Fatalf("RunLeaderChurn: %v", werr)
+	}
+	if written == 0 {
+		cx.t.Fatal("RunLeaderChurn: no writes committed during churn")
+	}
+
+	var rev int64
+	for _, ep := range cx.epc.EndpointsV3() {
+		epCli := newClient(cx.t, []string{ep}, cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+		resp, err := epCli.Get(context.TODO(), "churn-", clientv3.WithPrefix(), clientv3.WithCountOnly())
+		if err != nil {
+			cx.t.Fatalf("RunLeaderChurn: get from %s failed (%v)", ep, err)
+		}
+		if resp.Count != int64(written) {
+			cx.t.Fatalf("RunLeaderChurn: expected %d keys on %s, got %d", written, ep, resp.Count)
+		}
+		rev = resp.Header.Revision
+	}
+	var hash uint32
+	for i, ep := range cx.epc.EndpointsV3() {
+		resp, err := cli.HashKV(context.TODO(), ep, rev)
+		if err != nil {
+			cx.t.Fatalf("RunLeaderChurn: hashkv on %s failed (%v)", ep, err)
+		}
+		if i > 0 && resp.Hash != hash {
+			cx.t.Fatalf("RunLeaderChurn: hash mismatch at revision %d, %s has %d, expected %d", rev, ep, resp.Hash, hash)
+		}
+		hash = resp.Hash
+	}
+}
+
+func TestCtlV3LeaderFailover(t *testing.T) {
+	testCtl(t, leaderFailoverTest, withQuorum())
+}
+
+// leaderFailoverTest stops the current leader and ensures one of the remaining
+// members is elected in its place.
+func leaderFailoverTest(cx ctlCtx) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	oldLeader, err := cx.epc.Leader(ctx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if err = oldLeader.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	for {
+		newLeader, err := cx.epc.Leader(ctx)
+		if err == nil {
+			if newLeader == oldLeader {
+				cx.t.Fatalf("expected a new leader, %s still leads", oldLeader.Config().Name)
+			}
+			cx.t.Logf("%s took over leadership from %s", newLeader.Config().Name, oldLeader.Config().Name)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			cx.t.Fatalf("no new leader elected after stopping %s (%v)", oldLeader.Config().Name, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+func TestCtlV3MoveLeaderRoundRobin(t *testing.T) {
+	testCtl(t, moveLeaderRoundRobinTest, withQuorum())
+}
+
+// moveLeaderRoundRobinTest hands leadership to every member in turn and
+// ensures all members agree on the new leader each time.
+func moveLeaderRoundRobinTest(cx ctlCtx) {
+	members, err := getMemberListJSON(cx)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	leaderName := cx.epc.Procs[cx.epc.WaitLeader(cx.t)].Config().Name
+	for _, m := range members.Members {
+		if m.Name != leaderName {
+			continue
+		}
+		if err = moveLeader(cx, m.ID); err == nil || !strings.Contains(err.Error(), "already the leader") {
+			cx.t.Fatalf("expected moving leadership to the leader %s to fail, got %v", m.Name, err)
+		}
+	}
+
+	for round := 0; round < 2; round++ {
+		for _, m := range members.Members {
+			if m.Name == leaderName {
+				continue
+			}
+			if err = moveLeader(cx, m.ID); err != nil {
+				cx.t.Fatalf("round %d: moving leadership to %s: %v", round, m.Name, err)
+			}
+			leaderName = m.Name
+			// the other followers learn about the new leader from its next append
+			deadline := time.Now().Add(5 * time.Second)
+			for !allReportLeader(cx, m.ID) {
+				if time.Now().After(deadline) {
+					cx.t.Fatalf("round %d: members do not agree %s leads", round, m.Name)
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+		}
+	}
+}
+
+// allReportLeader reports whether every member reports leaderID as leader.
+func allReportLeader(cx ctlCtx, leaderID uint64) bool {
+	statuses, err := getEndpointStatusJSON(cx)
+	if err != nil {
+		return false
+	}
+	for _, st := range statuses {
+		if st.Status.Leader != leaderID {
+			return false
+		}
+	}
+	return len(statuses) == len(cx.epc.Procs)
+}
+
+// moveLeaderToFollower transfers leadership from the current leader to one of its followers.
+func moveLeaderToFollower(cx ctlCtx) error {
+	leadIdx := cx.epc.WaitLeader(cx.t)
+	followerEP := cx.epc.Procs[(leadIdx+1)%len(cx.epc.Procs)].EndpointsV3()
+
+	fcli := newClient(cx.t, followerEP, cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	sresp, err := fcli.Status(context.TODO(), followerEP[0])
+	if err != nil {
+		return err
+	}
+	return moveLeader(cx, sresp.Header.MemberId)
+}
+
+// moveLeader transfers leadership to the member with the given ID by running
+// "move-leader" against the current leader, and waits until the target
+// reports itself as leader. It fails if the target already leads or is a
+// learner, which cannot lead.
+func moveLeader(cx ctlCtx, targetMemberID uint64) error {
+	statuses, err := getEndpointStatusJSON(cx)
+	if err != nil {
+		return err
+	}
+	var leaderEP string
+	var target *clientv3.StatusResponse
+	for _, st := range statuses {
+		if st.Status.Header.MemberId == st.Status.Leader {
+			leaderEP = st.Endpoint
+		}
+		if st.Status.Header.MemberId == targetMemberID {
+			target = st.Status
+		}
+	}
+	switch {
+	case target == nil:
+		return fmt.Errorf("member %s is not in the cluster", types.ID(targetMemberID))
+	case target.IsLearner:
+		return fmt.Errorf("member %s is a learner and cannot lead", types.ID(targetMemberID))
+	case target.Leader == targetMemberID:
+		return fmt.Errorf("member %s is already the leader", types.ID(targetMemberID))
+	case leaderEP == "":
+		return fmt.Errorf("no leader among %v", cx.epc.EndpointsV3())
+	}
+
+	cmdArgs := append(cx.prefixArgs([]string{leaderEP}), "move-leader", types.ID(targetMemberID).String())
+	if err = e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "Leadership transferred"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for {
+		statuses, err = getEndpointStatusJSON(cx)
+		if err == nil {
+			for _, st := range statuses {
+				if st.Status.Header.MemberId == targetMemberID && st.Status.Leader == targetMemberID {
+					return nil
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("member %s did not report leadership after move-leader (%v)", types.ID(targetMemberID), err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -16,6 +16,7 @@ package e2e
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,11 +28,11 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	v3rpc "go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/etcdutl/v3/snapshot"
 	"go.etcd.io/etcd/pkg/v3/expect"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3Snapshot(t *testing.T)        { testCtl(t, snapshotTest) }
@@ -135,11 +136,178 @@ func snapshotStatusBeforeRestoreTest(cx ctlCtx) {
 	}
 }
 
+func TestCtlV3SnapshotRestoreRoundTrip(t *testing.T) { testCtl(t, snapshotRestoreRoundTripTest) }
+func TestCtlV3SnapshotRestoreRoundTripEtcdutl(t *testing.T) {
+	testCtl(t, snapshotRestoreRoundTripTest, withEtcdutl())
+}
+
+// snapshotRestoreRoundTripTest saves a snapshot of the cluster, restores it
+// into a fresh data dir of every member and ensures the cluster restarted
+// from it serves the saved keys.
+func snapshotRestoreRoundTripTest(cx ctlCtx) {
+	var kvs []kv
+	for i := 0; i < 10; i++ {
+		kvs = append(kvs, kv{fmt.Sprintf("key%d", i), fmt.Sprintf("val%d", i)})
+		if err := ctlV3Put(cx, kvs[i].key, kvs[i].val, ""); err != nil {
+			cx.t.Fatalf("snapshotRestoreRoundTripTest ctlV3Put error (%v)", err)
+		}
+	}
+	fpath := filepath.Join(cx.t.TempDir(), "snapshot")
+	if err := ctlV3SnapshotSave(cx, fpath); err != nil {
+		cx.t.Fatalf("snapshotRestoreRoundTripTest ctlV3SnapshotSave error (%v)", err)
+	}
+
+	if err := cx.epc.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+	for _, proc := range cx.epc.Procs {
+		if err := restoreMember(cx, proc.Config(), fpath); err != nil {
+			cx.t.Fatalf("snapshotRestoreRoundTripTest restoreMember error (%v)", err)
+		}
+	}
+	if err := cx.epc.Start(); err != nil {
+		cx.t.Fatalf("could not start the restored etcd process cluster (%v)", err)
+	}
+
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	resp, err := cli.Get(context.TODO(), "key", clientv3.WithPrefix())
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	var got []kv
+	for _, ev := range resp.Kvs {
+		got = append(got, kv{string(ev.Key), string(ev.Value)})
+	}
+	require.Equal(cx.t, kvs, got)
+}
+
+func TestCtlV3SnapshotRestoreKeepsRevision(t *testing.T) {
+	testCtl(t, snapshotRestoreKeepsRevisionTest, withQuorum())
+}
+
+// snapshotRestoreKeepsRevisionTest restores every member of the cluster from
+// a snapshot and ensures the restored cluster resumes at the revision of the
+// snapshot, so watches can resume from revisions seen before the restore.
+func snapshotRestoreKeepsRevisionTest(cx ctlCtx) {
+	for i := 0; i < 10; i++ {
+		if err := ctlV3Put(cx, "key", fmt.Sprintf("val%d", i), ""); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	resp, err := ctlV3GetJSON(cx, "key")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	rev := resp.Header.Revision
+	fpath := filepath.Join(cx.t.TempDir(), "snapshot")
+	if err = ctlV3SnapshotSave(cx, fpath); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if err = cx.epc.Stop(); err != nil {
+		cx.t.Fatal(err)
+	}
+	for _, proc := range cx.epc.Procs {
+		if err = restoreMember(cx, proc.Config(), fpath); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	if err = cx.epc.Start(); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if resp, err = ctlV3GetJSON(cx, "key"); err != nil {
+		cx.t.Fatal(err)
+	}
+	if resp.Header.Revision != rev || len(resp.Kvs) != 1 || resp.Kvs[0].ModRevision != rev {
+		cx.t.Fatalf("expected the restored cluster at revision %d, got header revision %d and kvs %+v", rev, resp.Header.Revision, resp.Kvs)
+	}
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	presp, err := cli.Put(context.TODO(), "key", "restored")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if presp.Header.Revision != rev+1 {
+		cx.t.Fatalf("expected the first write after the restore at revision %d, got %d", rev+1, presp.Header.Revision)
+	}
+}
+
+func TestCtlV3SnapshotConcurrentSave(t *testing.T) { testCtl(t, snapshotConcurrentSaveTest) }
+
+// snapshotConcurrentSaveTest ensures concurrent snapshot saves from different
+// clients don't interfere with each other, each producing a complete snapshot.
+func snapshotConcurrentSaveTest(cx ctlCtx) {
+	maintenanceInitKeys(cx)
+
+	eps := cx.epc.EndpointsV3()
+	fpaths := make([]string, 2*len(eps))
+	errc := make(chan error, len(fpaths))
+	for i := range fpaths {
+		fpaths[i] = filepath.Join(cx.t.TempDir(), fmt.Sprintf("snapshot-%d", i))
+		go func(ep, fpath string) {
+			errc <- ctlV3SnapshotSaveWithEndpoints(cx, []string{ep}, fpath)
+		}(eps[i%len(eps)], fpaths[i])
+	}
+	for range fpaths {
+		if err := <-errc; err != nil {
+			cx.t.Fatalf("snapshotConcurrentSaveTest ctlV3SnapshotSave error (%v)", err)
+		}
+	}
+
+	// snapshots saved from the same member must be identical
+	hashes := make(map[string]uint32)
+	for i, fpath := range fpaths {
+		st, err := getSnapshotStatus(cx, fpath)
+		if err != nil {
+			cx.t.Fatalf("snapshotConcurrentSaveTest getSnapshotStatus error (%v)", err)
+		}
+		if st.Revision != 4 {
+			cx.t.Fatalf("%s: expected revision 4, got %d", fpath, st.Revision)
+		}
+		ep := eps[i%len(eps)]
+		if h, ok := hashes[ep]; ok && h != st.Hash {
+			cx.t.Fatalf("%s: expected hash %d of the other snapshot from %s, got %d", fpath, h, ep, st.Hash)
+		}
+		hashes[ep] = st.Hash
+	}
+}
+
 func ctlV3SnapshotSave(cx ctlCtx, fpath string) error {
-	cmdArgs := append(cx.PrefixArgs(), "snapshot", "save", fpath)
+	return ctlV3SnapshotSaveWithEndpoints(cx, cx.epc.EndpointsV3(), fpath)
+}
+
+func ctlV3SnapshotSaveWithEndpoints(cx ctlCtx, eps []string, fpath string) error {
+	cmdArgs := append(cx.prefixArgs(eps), "snapshot", "save", fpath)
 	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, fmt.Sprintf("Snapshot saved at %s", fpath))
 }
 
+// RestoreOpts configures the member a snapshot is restored for.
+type RestoreOpts struct {
+	Name                string
+	InitialCluster      string
+	InitialClusterToken string
+	PeerURLs            string // comma separated --initial-advertise-peer-urls
+}
+
+// snapshotRestore restores the snapshot at snapPath into dataDir, with etcdctl
+// or etcdutl depending on cx.
+func snapshotRestore(cx ctlCtx, snapPath, dataDir string, opts RestoreOpts) error {
+	cmdArgs := append(cx.PrefixArgsUtl(), "snapshot", "restore", snapPath, "--data-dir", dataDir)
+	if opts.Name != "" {
+		cmdArgs = append(cmdArgs, "--name", opts.Name)
+	}
+	if opts.InitialCluster != "" {
+		cmdArgs = append(cmdArgs, "--initial-cluster", opts.InitialCluster)
+	}
+	if opts.InitialClusterToken != "" {
+		cmdArgs = append(cmdArgs, "--initial-cluster-token", opts.InitialClusterToken)
+	}
+	if opts.PeerURLs != "" {
+		cmdArgs = append(cmdArgs, "--initial-advertise-peer-urls", opts.PeerURLs)
+	}
+	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "added member")
+}
+
 func getSnapshotStatus(cx ctlCtx, fpath string) (snapshot.Status, error) {
 	cmdArgs := append(cx.PrefixArgsUtl(), "--write-out", "json", "snapshot", "status", fpath)
 
@@ -422,6 +590,223 @@ func TestRestoreCompactionRevBump(t *testing.T) {
 
 }
 
+// TestSnapshotRestoreDuringSourceCompaction restores a snapshot into a second
+// cluster while the cluster the snapshot was taken from keeps compacting.
+// The restore works from the static snapshot file, so ongoing maintenance on
+// the source cluster must not affect it.
+func TestSnapshotRestoreDuringSourceCompaction(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	src, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:  1,
+		InitialToken: "source",
+		BasePort:     21000,
+	})
+	if err != nil {
+		t.Fatalf("could not start source etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := src.Close(); errC != nil {
+			t.Fatalf("error closing source etcd processes (%v)", errC)
+		}
+	}()
+
+	srcCli := newClient(t, src.EndpointsV3(), src.Cfg.ClientTLS, src.Cfg.IsClientAutoTLS)
+	kvs := []kv{{"foo1", "val1"}, {"foo2", "val2"}, {"foo3", "val3"}}
+	for i := range kvs {
+		_, err = srcCli.Put(context.Background(), kvs[i].key, kvs[i].val)
+		require.NoError(t, err)
+	}
+
+	fpath := filepath.Join(t.TempDir(), "test.snapshot")
+	t.Log("etcdctl saving snapshot from the source cluster...")
+	require.NoError(t, e2e.SpawnWithExpect(
+		[]string{e2e.CtlBinPath, "--endpoints", strings.Join(src.EndpointsV3(), ","), "snapshot", "save", fpath},
+		fmt.Sprintf("Snapshot saved at %s", fpath)))
+	snapHash := fileSHA256(t, fpath)
+
+	dst, err := e2e.InitEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:  1,
+		InitialToken: "destination",
+		BasePort:     22000,
+	})
+	if err != nil {
+		t.Fatalf("could not init destination etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := dst.Close(); errC != nil {
+			t.Fatalf("error closing destination etcd processes (%v)", errC)
+		}
+	}()
+
+	// keep overwriting and compacting the source cluster until the restore is done
+	ctx, cancel := context.WithCancel(context.Background())
+	donec := make(chan error, 1)
+	go func() {
+		defer close(donec)
+		for i := 0; ctx.Err() == nil; i++ {
+			resp, perr := srcCli.Put(ctx, kvs[i%len(kvs)].key, fmt.Sprintf("overwritten-%d", i))
+			if perr != nil {
+				if ctx.Err() == nil {
+					donec <- perr
+				}
+				return
+			}
+			if _, perr = srcCli.Compact(ctx, resp.Header.Revision, clientv3.WithCompactPhysical()); perr != nil && ctx.Err() == nil {
+				donec <- perr
+				return
+			}
+		}
+	}()
+
+	cfg := dst.Procs[0].Config()
+	newDataDir := filepath.Join(t.TempDir(), "test.data")
+	t.Log("etcdutl restoring the snapshot into the destination cluster...")
+	err = e2e.SpawnWithExpect([]string{
+		e2e.UtlBinPath,
+		"snapshot",
+		"restore", fpath,
+		"--name", cfg.Name,
+		"--initial-cluster", cfg.InitialCluster,
+		"--initial-cluster-token", cfg.InitialToken,
+		"--initial-advertise-peer-urls", cfg.Purl.String(),
+		"--data-dir", newDataDir,
+	}, "added member")
+	cancel()
+	require.NoError(t, err)
+	require.NoError(t, <-donec, "source cluster maintenance failed")
+	require.Equal(t, snapHash, fileSHA256(t, fpath), "snapshot file changed during restore")
+
+	setMemberDataDir(cfg, newDataDir)
+	t.Log("Starting the destination cluster from the restored snapshot...")
+	require.NoError(t, dst.Start())
+
+	t.Log("Ensuring the destination cluster has the data of the snapshot...")
+	dstCli := newClient(t, dst.EndpointsV3(), dst.Cfg.ClientTLS, dst.Cfg.IsClientAutoTLS)
+	hasKVs(t, dstCli, kvs, 4, 2)
+}
+
+// TestSnapshotRestoreMemberDuringCompaction restores one member of a cluster
+// from an old snapshot while the rest of the cluster keeps writing and
+// compacting. The rejoining member is behind the leader's compacted raft log,
+// so it must catch up from a raft snapshot and advance past the compaction.
+func TestSnapshotRestoreMemberDuringCompaction(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	cfg := e2e.NewConfigNoTLS()
+	cfg.SnapshotCount = 100
+	epc, err := e2e.NewEtcdProcessCluster(t, cfg)
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	restored := epc.Procs[2]
+	cli := newClient(t, append(epc.Procs[0].EndpointsV3(), epc.Procs[1].EndpointsV3()...), cfg.ClientTLS, cfg.IsClientAutoTLS)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	kvs := []kv{{"foo1", "val1"}, {"foo2", "val2"}, {"foo3", "val3"}}
+	for i := range kvs {
+		_, err = cli.Put(ctx, kvs[i].key, kvs[i].val)
+		require.NoError(t, err)
+	}
+	fpath := filepath.Join(t.TempDir(), "test.snapshot")
+	t.Log("etcdctl saving snapshot...")
+	require.NoError(t, e2e.SpawnWithExpect(
+		[]string{e2e.CtlBinPath, "--endpoints", strings.Join(restored.EndpointsV3(), ","), "snapshot", "save", fpath},
+		fmt.Sprintf("Snapshot saved at %s", fpath)))
+
+	t.Log("Stopping the member to restore...")
+	require.NoError(t, restored.Stop())
+
+	// the leader only keeps 5000 raft entries past its latest snapshot, write
+	// enough that the restored member cannot catch up from the raft log alone
+	const writes = 6000
+	donec := make(chan error, 1)
+	go func() {
+		defer close(donec)
+		for i := 0; i < writes; i++ {
+			resp, perr := cli.Put(ctx, kvs[i%len(kvs)].key, fmt.Sprintf("overwritten-%d", i))
+			if perr != nil {
+				donec <- perr
+				return
+			}
+			if i%500 == 0 {
+				if _, perr = cli.Compact(ctx, resp.Header.Revision); perr != nil {
+					donec <- perr
+					return
+				}
+			}
+		}
+	}()
+
+	t.Log("etcdutl restoring the snapshot while the cluster compacts...")
+	require.NoError(t, restoreMember(ctlCtx{t: t, epc: epc, etcdutl: true}, restored.Config(), fpath))
+	require.NoError(t, <-donec, "cluster maintenance failed")
+
+	resp, err := cli.Get(ctx, kvs[0].key)
+	require.NoError(t, err)
+	compactRev := resp.Header.Revision
+	_, err = cli.Compact(ctx, compactRev, clientv3.WithCompactPhysical())
+	require.NoError(t, err)
+
+	t.Log("Restarting the restored member...")
+	require.NoError(t, restored.Start())
+
+	t.Log("Ensuring the restored member catches up past the compaction...")
+	rcli := newClient(t, restored.EndpointsV3(), cfg.ClientTLS, cfg.IsClientAutoTLS)
+	require.Eventually(t, func() bool {
+		rresp, gerr := rcli.Get(ctx, kvs[0].key, clientv3.WithSerializable())
+		return gerr == nil && rresp.Header.Revision >= compactRev
+	}, 30*time.Second, 100*time.Millisecond)
+
+	_, err = rcli.Get(ctx, kvs[0].key, clientv3.WithSerializable(), clientv3.WithRev(compactRev-1))
+	require.ErrorIs(t, err, v3rpc.ErrCompacted)
+
+	want, err := cli.HashKV(ctx, epc.Procs[0].EndpointsV3()[0], compactRev)
+	require.NoError(t, err)
+	for _, proc := range epc.Procs[1:] {
+		got, herr := cli.HashKV(ctx, proc.EndpointsV3()[0], compactRev)
+		require.NoError(t, herr)
+		require.Equal(t, compactRev, got.CompactRevision, "member %s compact revision", proc.Config().Name)
+		require.Equal(t, want.Hash, got.Hash, "member %s hash", proc.Config().Name)
+	}
+}
+
+// restoreMember restores the snapshot at fpath into a new data dir of the
+// member, keeping its name, peer URL and cluster, and points the member config
+// at it.
+func restoreMember(cx ctlCtx, cfg *e2e.EtcdServerProcessConfig, fpath string) error {
+	dataDir := filepath.Join(cx.t.TempDir(), "restored.data")
+	if err := snapshotRestore(cx, fpath, dataDir, RestoreOpts{
+		Name:                cfg.Name,
+		InitialCluster:      cfg.InitialCluster,
+		InitialClusterToken: cfg.InitialToken,
+		PeerURLs:            cfg.Purl.String(),
+	}); err != nil {
+		return err
+	}
+	setMemberDataDir(cfg, dataDir)
+	return nil
+}
+
+// setMemberDataDir points the member config at dataDir.
+func setMemberDataDir(cfg *e2e.EtcdServerProcessConfig, dataDir string) {
+	cfg.DataDirPath = dataDir
+	cfg.Args = e2e.PatchArgs(cfg.Args, "data-dir", dataDir)
+}
+
+func fileSHA256(t *testing.T, fpath string) [sha256.Size]byte {
+	b, err := os.ReadFile(fpath)
+	require.NoError(t, err)
+	return sha256.Sum256(b)
+}
+
 func hasKVs(t *testing.T, ctl *clientv3.Client, kvs []kv, currentRev int, baseRev int) {
 	for i := range kvs {
 		v, err := ctl.Get(context.Background(), kvs[i].key)
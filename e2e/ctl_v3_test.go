@@ -15,18 +15,21 @@
 package e2e
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/version"
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
 	"go.etcd.io/etcd/pkg/v3/flags"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestCtlV3Version(t *testing.T) { testCtl(t, versionTest) }
@@ -89,25 +92,52 @@ func versionTest(cx ctlCtx) {
 }
 
 func clusterVersionTest(cx ctlCtx, expected string) {
-	var err error
-	for i := 0; i < 35; i++ {
-		if err = e2e.CURLGet(cx.epc, e2e.CURLReq{Endpoint: "/version", Expected: expected}); err != nil {
-			cx.t.Logf("#%d: v3 is not ready yet (%v)", i, err)
-			time.Sleep(200 * time.Millisecond)
-			continue
-		}
-		break
-	}
-	if err != nil {
+	if err := waitClusterVersion(cx, expected, 14*time.Second); err != nil {
 		cx.t.Fatalf("failed cluster version test expected %v got (%v)", expected, err)
 	}
 }
 
+// waitClusterVersion waits until every member is healthy and /version
+// reports expected, which the leader only decides once the cluster is healthy.
+func waitClusterVersion(cx ctlCtx, expected string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := waitClusterHealthy(cx, timeout); err != nil {
+		return err
+	}
+	for {
+		err := e2e.CURLGet(cx.epc, e2e.CURLReq{Endpoint: "/version", Expected: expected})
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cluster version still not %s after %v (%v)", expected, timeout, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
 func ctlV3Version(cx ctlCtx) error {
 	cmdArgs := append(cx.PrefixArgs(), "version")
 	return e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, version.Version)
 }
 
+// TestCtlV3SpawnWithExpectError ensures SpawnWithExpectError only accepts a
+// command failing with the expected error.
+func TestCtlV3SpawnWithExpectError(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	invalid := []string{e2e.CtlBinPath, "nosuchcmd"}
+	if err := e2e.SpawnWithExpectError(invalid, nil, `unknown command "nosuchcmd"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := e2e.SpawnWithExpectError(invalid, nil, "no such output"); err == nil {
+		t.Fatal("expected a failure with a different error to be rejected")
+	}
+	if err := e2e.SpawnWithExpectError([]string{e2e.CtlBinPath, "version"}, nil, "Error"); err == nil {
+		t.Fatal("expected a succeeding command to be rejected")
+	}
+}
+
 // TestCtlV3DialWithHTTPScheme ensures that client handles Endpoints with HTTPS scheme.
 func TestCtlV3DialWithHTTPScheme(t *testing.T) {
 	testCtl(t, dialWithSchemeTest, withCfg(*e2e.NewConfigClientTLS()))
@@ -120,6 +150,160 @@ func dialWithSchemeTest(cx ctlCtx) {
 	}
 }
 
+// TestCtlV3ClientUnixEndpoints ensures clients built by newClient reach
+// members listening for clients on unix sockets, with and without TLS.
+func TestCtlV3ClientUnixEndpoints(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  *e2e.EtcdProcessClusterConfig
+	}{
+		{name: "NoTLS", cfg: e2e.NewConfigNoTLS()},
+		{name: "ClientTLS", cfg: e2e.NewConfigClientTLS()},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cfg.BaseClientScheme = "unix"
+			testCtl(t, clientUnixEndpointsTest, withCfg(*tc.cfg))
+		})
+	}
+}
+
+func clientUnixEndpointsTest(cx ctlCtx) {
+	eps := cx.epc.EndpointsV3()
+	for _, ep := range eps {
+		if !strings.HasPrefix(ep, "unix") {
+			cx.t.Fatalf("expected a unix socket endpoint, got %s", ep)
+		}
+	}
+	cli := newClient(cx.t, eps, cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	if _, err := cli.Put(context.TODO(), "foo", "bar"); err != nil {
+		cx.t.Fatal(err)
+	}
+	resp, err := cli.Get(context.TODO(), "foo")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(resp.Kvs) != 1 || string(resp.Kvs[0].Value) != "bar" {
+		cx.t.Fatalf("expected foo=bar, got %+v", resp.Kvs)
+	}
+}
+
+// TestCtlV3EndpointsFlagForms ensures that a single comma-separated --endpoints
+// flag and repeated --endpoints flags are parsed identically.
+func TestCtlV3EndpointsFlagForms(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []ctlOption
+	}{
+		{name: "comma-separated", opts: []ctlOption{withQuorum()}},
+		{name: "repeated", opts: []ctlOption{withQuorum(), withRepeatedEndpoints()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			testCtl(t, endpointsFlagFormsTest, tc.opts...)
+		})
+	}
+}
+
+func endpointsFlagFormsTest(cx ctlCtx) {
+	if err := ctlV3EndpointHealth(cx); err != nil {
+		cx.t.Fatalf("endpointsFlagFormsTest ctlV3EndpointHealth error (%v)", err)
+	}
+	for i, ep := range cx.epc.EndpointsV3() {
+		key, val := fmt.Sprintf("foo%d", i), fmt.Sprintf("bar%d", i)
+		cmdArgs := append(cx.prefixArgs([]string{ep}), "put", key, val)
+		if err := e2e.SpawnWithExpectWithEnv(cmdArgs, cx.envMap, "OK"); err != nil {
+			cx.t.Fatalf("endpointsFlagFormsTest put via %s error (%v)", ep, err)
+		}
+	}
+	for i := range cx.epc.EndpointsV3() {
+		key, val := fmt.Sprintf("foo%d", i), fmt.Sprintf("bar%d", i)
+		if err := ctlV3Get(cx, []string{key}, kv{key, val}); err != nil {
+			cx.t.Fatalf("endpointsFlagFormsTest ctlV3Get error (%v)", err)
+		}
+	}
+}
+
+// TestCtlV3MemberLogsCapture ensures the captured member logs span restarts.
+func TestCtlV3MemberLogsCapture(t *testing.T) {
+	testCtl(t, memberLogsCaptureTest, withLogOutputCapture())
+}
+
+func memberLogsCaptureTest(cx ctlCtx) {
+	if err := cx.epc.Procs[0].Restart(); err != nil {
+		cx.t.Fatal(err)
+	}
+	logs := cx.epc.MemberLogs(0)
+	if len(logs) > defaultLogCaptureLines {
+		cx.t.Fatalf("expected at most %d captured lines, got %d", defaultLogCaptureLines, len(logs))
+	}
+	ready := 0
+	for _, l := range logs {
+		if strings.Contains(l, e2e.EtcdServerReadyLines[0]) {
+			ready++
+		}
+	}
+	if ready != 2 {
+		cx.t.Fatalf("expected %q logged once per start, got %d times", e2e.EtcdServerReadyLines[0], ready)
+	}
+}
+
+// TestCtlV3MemberLogsCaptureBounded ensures only the latest LogCaptureLines
+// lines are kept.
+func TestCtlV3MemberLogsCaptureBounded(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	const captureLines = 10
+	cfg := e2e.NewConfigNoTLS()
+	cfg.ClusterSize = 1
+	cfg.LogCaptureLines = captureLines
+	epc, err := e2e.NewEtcdProcessCluster(t, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer epc.Close()
+
+	if err = epc.Procs[0].Restart(); err != nil {
+		t.Fatal(err)
+	}
+	if logs := epc.MemberLogs(0); len(logs) != captureLines {
+		t.Fatalf("expected %d captured lines, got %d", captureLines, len(logs))
+	}
+	// the lines are still kept once the member stops
+	if err = epc.Procs[0].Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if logs := epc.MemberLogs(0); len(logs) != captureLines {
+		t.Fatalf("expected %d captured lines after stopping, got %d", captureLines, len(logs))
+	}
+}
+
+// TestCtlV3DataDirRoot ensures every member keeps its data under the
+// configured data dir root.
+func TestCtlV3DataDirRoot(t *testing.T) {
+	testCtl(t, dataDirRootTest, withQuorum(), withDataDirRoot(t.TempDir()))
+}
+
+func dataDirRootTest(cx ctlCtx) {
+	root := cx.epc.Cfg.DataDirRoot
+	dirs := make(map[string]bool)
+	for _, proc := range cx.epc.Procs {
+		dir := proc.Config().DataDirPath
+		if filepath.Dir(dir) != root {
+			cx.t.Fatalf("expected the data dir of %s under %s, got %s", proc.Config().Name, root, dir)
+		}
+		if dirs[dir] {
+			cx.t.Fatalf("data dir %s is shared by several members", dir)
+		}
+		dirs[dir] = true
+		if !fileutil.Exist(filepath.Join(dir, "member", "snap", "db")) {
+			cx.t.Fatalf("expected the backend of %s in %s", proc.Config().Name, dir)
+		}
+	}
+}
+
+// defaultLogCaptureLines is how many output lines withLogOutputCapture keeps
+// per member.
+const defaultLogCaptureLines = 1000
+
 type ctlCtx struct {
 	t                 *testing.T
 	apiPrefix         string
@@ -132,15 +316,37 @@ type ctlCtx struct {
 
 	envMap map[string]string
 
-	dialTimeout time.Duration
-	testTimeout time.Duration
+	dialTimeout    time.Duration
+	testTimeout    time.Duration
+	commandTimeout time.Duration
+	startupTimeout time.Duration
+
+	// if non-zero, every member keeps that many of its latest output
+	// lines, see withLogOutputCapture.
+	logCaptureLines int
 
 	quorum      bool // if true, set up 3-node cluster and linearizable read
 	interactive bool
 
+	// if true, the cluster size set by withClusterSize is kept even
+	// without quorum.
+	clusterSizeSet bool
+
 	user string
 	pass string
 
+	// if set, authentication is enabled once the cluster is up and the
+	// test runs as this user, see withAuth.
+	authUser string
+	authPass string
+
+	// if set, the test authenticates with a client certificate issued for
+	// this CommonName by clientCA, see withClientCertCN.
+	clientCertCN string
+	clientCA     *clientCA
+	clientCert   string
+	clientKey    string
+
 	initialCorruptCheck bool
 
 	// for compaction
@@ -151,6 +357,10 @@ type ctlCtx struct {
 
 	// dir that was used during the test
 	dataDir string
+
+	// if true, emit one --endpoints flag per endpoint instead of
+	// a single comma-separated --endpoints flag.
+	repeatEndpoints bool
 }
 
 type ctlOption func(*ctlCtx)
@@ -170,14 +380,46 @@ func withDialTimeout(timeout time.Duration) ctlOption {
 	return func(cx *ctlCtx) { cx.dialTimeout = timeout }
 }
 
+func withCommandTimeout(timeout time.Duration) ctlOption {
+	return func(cx *ctlCtx) { cx.commandTimeout = timeout }
+}
+
 func withTestTimeout(timeout time.Duration) ctlOption {
 	return func(cx *ctlCtx) { cx.testTimeout = timeout }
 }
 
+// withStartupTimeout fails the test if the cluster members are not ready
+// within timeout.
+func withStartupTimeout(timeout time.Duration) ctlOption {
+	return func(cx *ctlCtx) { cx.startupTimeout = timeout }
+}
+
+// withLogOutputCapture keeps the latest log lines of every member, which
+// the test can then inspect with cx.epc.MemberLogs.
+func withLogOutputCapture() ctlOption {
+	return func(cx *ctlCtx) { cx.logCaptureLines = defaultLogCaptureLines }
+}
+
 func withQuorum() ctlOption {
 	return func(cx *ctlCtx) { cx.quorum = true }
 }
 
+// withAuth enables authentication before the test runs, with user granted the
+// root role, and runs the test as that user.
+func withAuth(user, pass string) ctlOption {
+	return func(cx *ctlCtx) {
+		cx.authUser = user
+		cx.authPass = pass
+	}
+}
+
+// withClientCertCN runs the test over client TLS with a client certificate
+// whose CommonName is cn, after enabling auth with a cn user granted the root
+// role, so requests authenticate as cn.
+func withClientCertCN(cn string) ctlOption {
+	return func(cx *ctlCtx) { cx.clientCertCN = cn }
+}
+
 func withInteractive() ctlOption {
 	return func(cx *ctlCtx) { cx.interactive = true }
 }
@@ -186,6 +428,17 @@ func withQuota(b int64) ctlOption {
 	return func(cx *ctlCtx) { cx.quotaBackendBytes = b }
 }
 
+// restartWithQuota restarts every member with a backend quota of b bytes in
+// place of the one set by withQuota.
+func (cx *ctlCtx) restartWithQuota(b int64) error {
+	cx.quotaBackendBytes = b
+	cx.epc.Cfg.QuotaBackendBytes = b
+	for _, proc := range cx.epc.Procs {
+		proc.Config().Args = e2e.PatchArgs(proc.Config().Args, "quota-backend-bytes", fmt.Sprintf("%d", b))
+	}
+	return cx.epc.Restart()
+}
+
 func withCompactPhysical() ctlOption {
 	return func(cx *ctlCtx) { cx.compactPhysical = true }
 }
@@ -214,6 +467,10 @@ func withEtcdutl() ctlOption {
 	return func(cx *ctlCtx) { cx.etcdutl = true }
 }
 
+func withRepeatedEndpoints() ctlOption {
+	return func(cx *ctlCtx) { cx.repeatEndpoints = true }
+}
+
 // This function must be called after the `withCfg`, otherwise its value
 // may be overwritten by `withCfg`.
 func withMaxConcurrentStreams(streams uint32) ctlOption {
@@ -230,6 +487,39 @@ func withSnapshotCount(snapshotCount int) ctlOption {
 	}
 }
 
+// This function must be called after the `withCfg`, otherwise its value
+// may be overwritten by `withCfg`.
+func withCompactionBatchLimit(n int) ctlOption {
+	return func(cx *ctlCtx) {
+		cx.cfg.CompactionBatchLimit = n
+	}
+}
+
+// This function must be called after the `withCfg`, otherwise its value
+// may be overwritten by `withCfg`.
+func withWatchProgressNotifyInterval(d time.Duration) ctlOption {
+	return func(cx *ctlCtx) {
+		cx.cfg.WatchProcessNotifyInterval = d
+	}
+}
+
+// This function must be called after the `withCfg`, otherwise its value
+// may be overwritten by `withCfg`.
+func withClusterSize(clusterSize int) ctlOption {
+	return func(cx *ctlCtx) {
+		cx.cfg.ClusterSize = clusterSize
+		cx.clusterSizeSet = true
+	}
+}
+
+// This function must be called after the `withCfg`, otherwise its value
+// may be overwritten by `withCfg`.
+func withDataDirRoot(root string) ctlOption {
+	return func(cx *ctlCtx) {
+		cx.cfg.DataDirRoot = root
+	}
+}
+
 func withLogLevel(logLevel string) ctlOption {
 	return func(cx *ctlCtx) {
 		cx.cfg.LogLevel = logLevel
@@ -248,22 +538,39 @@ func getDefaultCtlCtx(t *testing.T) ctlCtx {
 	}
 }
 
+// applyClusterOpts applies the options affecting the cluster to cx.cfg.
+func (cx *ctlCtx) applyClusterOpts(t *testing.T) {
+	if !cx.quorum && !cx.clusterSizeSet {
+		cx.cfg = *e2e.ConfigStandalone(cx.cfg)
+	}
+	if cx.quotaBackendBytes > 0 {
+		cx.cfg.QuotaBackendBytes = cx.quotaBackendBytes
+	}
+	cx.cfg.NoStrictReconfig = cx.noStrictReconfig
+	if cx.initialCorruptCheck {
+		cx.cfg.InitialCorruptCheck = cx.initialCorruptCheck
+	}
+	if cx.startupTimeout > 0 {
+		cx.cfg.StartupTimeout = cx.startupTimeout
+	}
+	if cx.logCaptureLines > 0 {
+		cx.cfg.LogCaptureLines = cx.logCaptureLines
+	}
+	if cx.clientCertCN != "" {
+		cx.clientCA = newClientCA(t)
+		cx.clientCert, cx.clientKey = cx.clientCA.issue(t, cx.clientCertCN)
+		cx.cfg.ClientTLS = e2e.ClientTLS
+		cx.cfg.ClientCertAuthEnabled = true
+		cx.cfg.TrustedCAFile = cx.clientCA.bundle(t, e2e.CaPath)
+	}
+}
+
 func testCtlWithOffline(t *testing.T, testFunc func(ctlCtx), testOfflineFunc func(ctlCtx), opts ...ctlOption) {
 	e2e.BeforeTest(t)
 
 	ret := getDefaultCtlCtx(t)
 	ret.applyOpts(opts)
-
-	if !ret.quorum {
-		ret.cfg = *e2e.ConfigStandalone(ret.cfg)
-	}
-	if ret.quotaBackendBytes > 0 {
-		ret.cfg.QuotaBackendBytes = ret.quotaBackendBytes
-	}
-	ret.cfg.NoStrictReconfig = ret.noStrictReconfig
-	if ret.initialCorruptCheck {
-		ret.cfg.InitialCorruptCheck = ret.initialCorruptCheck
-	}
+	ret.applyClusterOpts(t)
 	if testOfflineFunc != nil {
 		ret.cfg.KeepDataDir = true
 	}
@@ -289,6 +596,19 @@ func testCtlWithOffline(t *testing.T, testFunc func(ctlCtx), testOfflineFunc fun
 		}
 	}()
 
+	if ret.authUser != "" {
+		if err := authEnableAs(&ret, ret.authUser, ret.authPass); err != nil {
+			t.Fatalf("could not enable auth (%v)", err)
+		}
+	}
+	if ret.clientCertCN != "" {
+		if err := authEnableAs(&ret, ret.clientCertCN, "pass"); err != nil {
+			t.Fatalf("could not enable auth (%v)", err)
+		}
+		// authenticate by the certificate CommonName instead of a password
+		ret.user, ret.pass = "", ""
+	}
+
 	donec := make(chan struct{})
 	go func() {
 		defer close(donec)
@@ -329,6 +649,9 @@ func (cx *ctlCtx) prefixArgs(eps []string) []string {
 	fmap := make(map[string]string)
 	fmap["endpoints"] = strings.Join(eps, ",")
 	fmap["dial-timeout"] = cx.dialTimeout.String()
+	if cx.commandTimeout != 0 {
+		fmap["command-timeout"] = cx.commandTimeout.String()
+	}
 	if cx.epc.Cfg.ClientTLS == e2e.ClientTLS {
 		if cx.epc.Cfg.IsClientAutoTLS {
 			fmap["insecure-transport"] = "false"
@@ -337,6 +660,10 @@ func (cx *ctlCtx) prefixArgs(eps []string) []string {
 			fmap["cacert"] = e2e.CaPath
 			fmap["cert"] = e2e.RevokedCertPath
 			fmap["key"] = e2e.RevokedPrivateKeyPath
+		} else if cx.clientCert != "" {
+			fmap["cacert"] = e2e.CaPath
+			fmap["cert"] = cx.clientCert
+			fmap["key"] = cx.clientKey
 		} else {
 			fmap["cacert"] = e2e.CaPath
 			fmap["cert"] = e2e.CertPath
@@ -350,6 +677,12 @@ func (cx *ctlCtx) prefixArgs(eps []string) []string {
 	useEnv := cx.envMap != nil
 
 	cmdArgs := []string{e2e.CtlBinPath + "3"}
+	if cx.repeatEndpoints && !useEnv {
+		delete(fmap, "endpoints")
+		for _, ep := range eps {
+			cmdArgs = append(cmdArgs, fmt.Sprintf("--endpoints=%s", ep))
+		}
+	}
 	for k, v := range fmap {
 		if useEnv {
 			ek := flags.FlagToEnv("ETCDCTL", k)
@@ -377,6 +710,28 @@ func (cx *ctlCtx) PrefixArgsUtl() []string {
 	return []string{e2e.CtlBinPath}
 }
 
+// runJSONCmd runs etcdctl with args and --write-out=json against the cluster
+// endpoints, and decodes its output into a T.
+func runJSONCmd[T any](cx ctlCtx, args ...string) (T, error) {
+	return runJSONCmdWithEndpoints[T](cx, cx.epc.EndpointsV3(), args...)
+}
+
+// runJSONCmdWithEndpoints is runJSONCmd against the given endpoints.
+func runJSONCmdWithEndpoints[T any](cx ctlCtx, eps []string, args ...string) (T, error) {
+	var resp T
+	cmdArgs := append(cx.prefixArgs(eps), "--write-out=json")
+	cmdArgs = append(cmdArgs, args...)
+	lines, err := e2e.RunUtilCompletion(cmdArgs, cx.envMap)
+	if err != nil {
+		return resp, err
+	}
+	out := strings.Join(lines, "\n")
+	if err = json.Unmarshal([]byte(out), &resp); err != nil {
+		return resp, fmt.Errorf("failed to decode %q: %w", out, err)
+	}
+	return resp, nil
+}
+
 func isGRPCTimedout(err error) bool {
 	return strings.Contains(err.Error(), "grpc: timed out trying to connect")
 }
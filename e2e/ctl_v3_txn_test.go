@@ -15,9 +15,13 @@
 package e2e
 
 import (
+	"context"
+	"fmt"
+	"strconv"
 	"testing"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 func TestCtlV3TxnInteractiveSuccess(t *testing.T) {
@@ -35,6 +39,12 @@ func TestCtlV3TxnInteractiveSuccessPeerTLS(t *testing.T) {
 func TestCtlV3TxnInteractiveFail(t *testing.T) {
 	testCtl(t, txnTestFail, withInteractive())
 }
+func TestCtlV3TxnInteractiveCreateIfAbsent(t *testing.T) {
+	testCtl(t, txnTestCreateIfAbsent, withInteractive())
+}
+func TestCtlV3TxnCompareAndSwap(t *testing.T) {
+	testCtl(t, txnTestCompareAndSwap)
+}
 
 func txnTestSuccess(cx ctlCtx) {
 	if err := ctlV3Put(cx, "key1", "value1", ""); err != nil {
@@ -93,6 +103,34 @@ func txnTestFail(cx ctlCtx) {
 	}
 }
 
+// txnTestCreateIfAbsent ensures a txn comparing the create revision to 0 only
+// creates a key that doesn't exist yet, leaving an existing key untouched.
+func txnTestCreateIfAbsent(cx ctlCtx) {
+	rqs := []txnRequests{
+		txnCreateIfAbsent("key1", "first", []string{"SUCCESS", "OK"}),
+		txnCreateIfAbsent("key1", "second", []string{"FAILURE", "key1", "first"}),
+	}
+	for _, rq := range rqs {
+		if err := ctlV3Txn(cx, rq); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	if err := ctlV3Get(cx, []string{"key1"}, kv{"key1", "first"}); err != nil {
+		cx.t.Fatal(err)
+	}
+}
+
+// txnCreateIfAbsent returns a txn putting key only if it has never been
+// created, or getting its current value otherwise.
+func txnCreateIfAbsent(key, val string, results []string) txnRequests {
+	return txnRequests{
+		compare:  []string{fmt.Sprintf(`create(%q) = "0"`, key)},
+		ifSucess: []string{fmt.Sprintf("put %q %q", key, val)},
+		ifFail:   []string{fmt.Sprintf("get %q", key)},
+		results:  results,
+	}
+}
+
 type txnRequests struct {
 	compare  []string
 	ifSucess []string
@@ -157,3 +195,117 @@ func ctlV3Txn(cx ctlCtx, rqs txnRequests) error {
 	}
 	return proc.Close()
 }
+
+// txnTestCompareAndSwap swaps a value only if it holds the expected one, so
+// a repeated swap takes the failure branch.
+func txnTestCompareAndSwap(cx ctlCtx) {
+	if err := ctlV3Put(cx, "key1", "value1", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	compares := []Cmp{{Key: "key1", Target: "value", Result: "=", Value: "value1"}}
+	success := []Op{{Type: "put", Key: "key1", Value: "value2"}}
+	failure := []Op{{Type: "put", Key: "key1", Value: "fail"}}
+	for i, tt := range []struct {
+		committed bool
+		val       string
+	}{
+		{committed: true, val: "value2"},
+		{committed: false, val: "fail"},
+	} {
+		committed, err := runTxn(cx, compares, success, failure)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if committed != tt.committed {
+			cx.t.Fatalf("#%d: expected the txn succeeded %v, got %v", i, tt.committed, committed)
+		}
+		if err = ctlV3Get(cx, []string{"key1"}, kv{"key1", tt.val}); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+}
+
+// Cmp compares the Target of Key, one of "value", "version", "create" or
+// "mod", to Value using Result, one of "=", "!=", "<" or ">". Value holds a
+// revision or version as a decimal for the targets other than "value".
+type Cmp struct {
+	Key    string
+	Target string
+	Result string
+	Value  string
+}
+
+func (c Cmp) clientCmp() (clientv3.Cmp, error) {
+	if c.Target == "value" {
+		return clientv3.Compare(clientv3.Value(c.Key), c.Result, c.Value), nil
+	}
+	n, err := strconv.ParseInt(c.Value, 10, 64)
+	if err != nil {
+		return clientv3.Cmp{}, fmt.Errorf("invalid %s %q to compare %s to (%v)", c.Target, c.Value, c.Key, err)
+	}
+	switch c.Target {
+	case "version":
+		return clientv3.Compare(clientv3.Version(c.Key), c.Result, n), nil
+	case "create":
+		return clientv3.Compare(clientv3.CreateRevision(c.Key), c.Result, n), nil
+	case "mod":
+		return clientv3.Compare(clientv3.ModRevision(c.Key), c.Result, n), nil
+	}
+	return clientv3.Cmp{}, fmt.Errorf("unknown compare target %q", c.Target)
+}
+
+// Op is a "put", "get" or "delete" of Key. Value is only used by a put.
+type Op struct {
+	Type  string
+	Key   string
+	Value string
+}
+
+func (o Op) clientOp() (clientv3.Op, error) {
+	switch o.Type {
+	case "put":
+		return clientv3.OpPut(o.Key, o.Value), nil
+	case "get":
+		return clientv3.OpGet(o.Key), nil
+	case "delete":
+		return clientv3.OpDelete(o.Key), nil
+	}
+	return clientv3.Op{}, fmt.Errorf("unknown op type %q", o.Type)
+}
+
+// runTxn runs the ops of success if all compares hold, or the ops of failure
+// otherwise, returning whether the compares held.
+func runTxn(cx ctlCtx, compares []Cmp, success, failure []Op) (committed bool, err error) {
+	cmps := make([]clientv3.Cmp, len(compares))
+	for i, c := range compares {
+		if cmps[i], err = c.clientCmp(); err != nil {
+			return false, err
+		}
+	}
+	thenOps, err := clientOps(success)
+	if err != nil {
+		return false, err
+	}
+	elseOps, err := clientOps(failure)
+	if err != nil {
+		return false, err
+	}
+
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	resp, err := cli.Txn(context.TODO()).If(cmps...).Then(thenOps...).Else(elseOps...).Commit()
+	if err != nil {
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
+func clientOps(ops []Op) ([]clientv3.Op, error) {
+	ret := make([]clientv3.Op, len(ops))
+	for i, o := range ops {
+		var err error
+		if ret[i], err = o.clientOp(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
@@ -15,9 +15,10 @@
 package e2e
 
 import (
+	"fmt"
 	"testing"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestCtlV3UserAdd(t *testing.T)      { testCtl(t, userAddTest) }
@@ -207,3 +208,9 @@ func ctlV3User(cx ctlCtx, args []string, expStr string, stdIn []string) error {
 	_, err = proc.Expect(expStr)
 	return err
 }
+
+// ctlV3UserAddNoPassword adds a user that has no password, so it can only
+// authenticate by a client certificate CommonName.
+func ctlV3UserAddNoPassword(cx ctlCtx, name string) error {
+	return ctlV3User(cx, []string{"add", name, "--no-password"}, fmt.Sprintf("User %s created", name), nil)
+}
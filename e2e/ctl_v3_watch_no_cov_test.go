@@ -21,7 +21,7 @@ import (
 	"os"
 	"testing"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestCtlV3Watch(t *testing.T)          { testCtl(t, watchTest) }
@@ -43,6 +43,28 @@ func TestCtlV3WatchInteractivePeerTLS(t *testing.T) {
 	testCtl(t, watchTest, withInteractive(), withCfg(*e2e.NewConfigPeerTLS()))
 }
 
+func TestCtlV3WatchNoQuorum(t *testing.T) { testCtl(t, watchNoQuorumTest, withQuorum()) }
+
+// watchNoQuorumTest ensures a watch against a member that lost its leader
+// fails instead of hanging. etcdctl watch does not honour --command-timeout;
+// it fails because it opens its watches with the require leader option, so
+// the member cancels them with the no leader error.
+func watchNoQuorumTest(cx ctlCtx) {
+	for _, proc := range cx.epc.Procs[1:] {
+		if err := proc.Stop(); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+	// wait for the remaining member to notice it has no leader
+	if _, err := cx.epc.Procs[0].Logs().Expect("lost leader"); err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if err := ctlV3WatchFailNoLeader(cx, cx.epc.Procs[0].EndpointsV3()[0], []string{"foo"}); err != nil {
+		cx.t.Fatalf("watchNoQuorumTest: ctlV3WatchFailNoLeader error (%v)", err)
+	}
+}
+
 func watchTest(cx ctlCtx) {
 	tests := []struct {
 		puts     []kv
@@ -15,11 +15,402 @@
 package e2e
 
 import (
+	"context"
+	"fmt"
+	"strconv"
 	"strings"
+	"testing"
+	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc/metadata"
 )
 
+// watchBatchMaxRevs mirrors the server side limit on the number of distinct
+// revisions sent to a catching-up watcher in a single watch response.
+const watchBatchMaxRevs = 1000
+
+// etcd has no flag limiting the number of events per watch response. The only
+// batching limit is watchBatchMaxRevs, applied to watchers that start from a
+// past revision and have to catch up with the store.
+func TestCtlV3WatchCatchUpBatching(t *testing.T) { testCtl(t, watchCatchUpBatchingTest) }
+
+func watchCatchUpBatchingTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	total := 3 * watchBatchMaxRevs
+	var startRev int64
+	for i := 0; i < total; i++ {
+		resp, err := cli.Put(context.TODO(), fmt.Sprintf("foo%d", i), "bar")
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if i == 0 {
+			startRev = resp.Header.Revision
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wch := cli.Watch(ctx, "foo", clientv3.WithPrefix(), clientv3.WithRev(startRev))
+	batches, err := watchEventBatches(wch, total)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if len(batches) < total/watchBatchMaxRevs {
+		cx.t.Fatalf("expected at least %d watch responses, got %d", total/watchBatchMaxRevs, len(batches))
+	}
+
+	rev := startRev
+	for i, batch := range batches {
+		if len(batch) > watchBatchMaxRevs {
+			cx.t.Fatalf("watch response #%d carries %d events, expected at most %d", i, len(batch), watchBatchMaxRevs)
+		}
+		for _, ev := range batch {
+			if ev.Kv.ModRevision != rev {
+				cx.t.Fatalf("expected event at revision %d, got %d", rev, ev.Kv.ModRevision)
+			}
+			rev++
+		}
+	}
+}
+
+// watchEventBatches receives from wch until want events are collected and
+// returns the events grouped by the watch response that carried them.
+func watchEventBatches(wch clientv3.WatchChan, want int) ([][]*clientv3.Event, error) {
+	var batches [][]*clientv3.Event
+	got := 0
+	for got < want {
+		wresp, ok := <-wch
+		if !ok {
+			return batches, fmt.Errorf("watch channel closed after %d of %d events", got, want)
+		}
+		if err := wresp.Err(); err != nil {
+			return batches, err
+		}
+		if len(wresp.Events) == 0 {
+			continue
+		}
+		batches = append(batches, wresp.Events)
+		got += len(wresp.Events)
+	}
+	return batches, nil
+}
+
+func TestCtlV3WatchMaxConcurrentStreams(t *testing.T) {
+	testCtl(t, watchMaxConcurrentStreamsTest, withCfg(*e2e.NewConfigNoTLS()), withMaxConcurrentStreams(3))
+}
+
+// watchMaxConcurrentStreamsTest opens one more watch stream than the server
+// allows on a single connection and ensures the extra stream is only
+// established once another one is closed.
+func watchMaxConcurrentStreamsTest(cx ctlCtx) {
+	limit := int(cx.cfg.MaxConcurrentStreams)
+	// a single endpoint keeps all the streams on the same connection
+	cli := newClient(cx.t, cx.epc.Procs[0].EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	cancels := make([]context.CancelFunc, limit)
+	for i := 0; i < limit; i++ {
+		var ctx context.Context
+		ctx, cancels[i] = context.WithCancel(context.Background())
+		defer cancels[i]()
+		select {
+		case wresp := <-watchStream(ctx, cli, i):
+			if !wresp.Created {
+				cx.t.Fatalf("expected watch stream #%d to be created, got %+v", i, wresp)
+			}
+		case <-time.After(5 * time.Second):
+			cx.t.Fatalf("watch stream #%d below the limit of %d was not established", i, limit)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wch := watchStream(ctx, cli, limit)
+	select {
+	case wresp := <-wch:
+		cx.t.Fatalf("expected watch stream over the limit of %d to block, got %+v", limit, wresp)
+	case <-time.After(2 * time.Second):
+	}
+
+	cancels[0]()
+	select {
+	case wresp := <-wch:
+		if !wresp.Created {
+			cx.t.Fatalf("expected blocked watch stream to be created, got %+v", wresp)
+		}
+	case <-time.After(5 * time.Second):
+		cx.t.Fatal("expected blocked watch stream to be established once another stream closed")
+	}
+}
+
+func TestCtlV3WatchMaxConcurrentStreamsUnlimited(t *testing.T) {
+	testCtl(t, watchMaxConcurrentStreamsUnlimitedTest, withCfg(*e2e.NewConfigNoTLS()), withMaxConcurrentStreams(0))
+}
+
+// watchMaxConcurrentStreamsUnlimitedTest ensures that a limit of 0 leaves the
+// server default, which doesn't limit the watch streams opened at once on a
+// single connection. watchMaxConcurrentStreamsTest covers a set limit.
+func watchMaxConcurrentStreamsUnlimitedTest(cx ctlCtx) {
+	const streams = 20
+	if got := establishedWatchStreams(cx, streams, 3*time.Second); got != streams {
+		cx.t.Fatalf("expected %d of %d watch streams established, got %d", streams, streams, got)
+	}
+}
+
+// watchStream opens a watch on "foo" over a gRPC stream of its own, as
+// watches with distinct metadata don't share a stream.
+func watchStream(ctx context.Context, cli *clientv3.Client, i int) clientv3.WatchChan {
+	ctx = metadata.AppendToOutgoingContext(ctx, "watch-stream", strconv.Itoa(i))
+	return cli.Watch(ctx, "foo", clientv3.WithCreatedNotify())
+}
+
+// establishedWatchStreams opens n watch streams at once on a single
+// connection and returns how many of them are established within timeout.
+func establishedWatchStreams(cx ctlCtx, n int, timeout time.Duration) int {
+	cli := newClient(cx.t, cx.epc.Procs[0].EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	createdc := make(chan struct{}, n)
+	for i := 0; i < n; i++ {
+		wch := watchStream(ctx, cli, i)
+		go func() {
+			if wresp, ok := <-wch; ok && wresp.Created {
+				createdc <- struct{}{}
+			}
+		}()
+	}
+
+	created := 0
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for created < n {
+		select {
+		case <-createdc:
+			created++
+		case <-timer.C:
+			return created
+		}
+	}
+	return created
+}
+
+func TestCtlV3WatchProgressNotifyInterval(t *testing.T) {
+	if !etcdSupportsFlag("--experimental-watch-progress-notify-interval") {
+		t.Skip("--experimental-watch-progress-notify-interval is not supported by the etcd binary")
+	}
+	testCtl(t, watchProgressNotifyIntervalTest, withWatchProgressNotifyInterval(500*time.Millisecond))
+}
+
+// watchProgressNotifyIntervalTest ensures an idle watch requesting progress
+// notifications gets one about every configured interval.
+func watchProgressNotifyIntervalTest(cx ctlCtx) {
+	interval := cx.cfg.WatchProcessNotifyInterval
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wch := cli.Watch(ctx, "foo/", clientv3.WithPrefix(), clientv3.WithCreatedNotify(), clientv3.WithProgressNotify())
+	if wresp := <-wch; !wresp.Created {
+		cx.t.Fatalf("expected watch to be created, got %+v", wresp)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case wresp := <-wch:
+			if !wresp.IsProgressNotify() {
+				cx.t.Fatalf("#%d: expected a progress notification on an idle watch, got %+v", i, wresp)
+			}
+		case <-time.After(2 * interval):
+			cx.t.Fatalf("#%d: no progress notification within %v", i, 2*interval)
+		}
+	}
+}
+
+func TestCtlV3WatchRevisionOrder(t *testing.T) { testCtl(t, watchRevisionOrderTest) }
+
+// watchRevisionOrderTest ensures a prefix watcher observes the events on
+// distinct keys, written concurrently, in global revision order without gaps.
+func watchRevisionOrderTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wch := cli.Watch(ctx, "foo/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+	if wresp := <-wch; !wresp.Created {
+		cx.t.Fatalf("expected watch to be created, got %+v", wresp)
+	}
+
+	const writers, writes = 5, 100
+	errc := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		go func(key string) {
+			for j := 0; j < writes; j++ {
+				if _, err := cli.Put(context.TODO(), key, strconv.Itoa(j)); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}(fmt.Sprintf("foo/%d", i))
+	}
+	for i := 0; i < writers; i++ {
+		if err := <-errc; err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	batches, err := watchEventBatches(wch, writers*writes)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	var rev int64
+	for _, batch := range batches {
+		for _, ev := range batch {
+			if rev != 0 && ev.Kv.ModRevision != rev+1 {
+				cx.t.Fatalf("expected event on %q at revision %d, got %d", ev.Kv.Key, rev+1, ev.Kv.ModRevision)
+			}
+			rev = ev.Kv.ModRevision
+		}
+	}
+}
+
+func TestCtlV3WatchPrefixNewKeys(t *testing.T) { testCtl(t, watchPrefixNewKeysTest) }
+
+// watchPrefixNewKeysTest ensures a prefix watch covers keys created after it
+// starts, wherever they sort relative to the keys present at watch start.
+func watchPrefixNewKeysTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	for _, key := range []string{"foo/b", "foo/d"} {
+		if _, err := cli.Put(context.TODO(), key, "existing"); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	wch := cli.Watch(ctx, "foo/", clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+	if wresp := <-wch; !wresp.Created {
+		cx.t.Fatalf("expected watch to be created, got %+v", wresp)
+	}
+
+	// fop sorts right after the prefix range and must not be seen, foo/z
+	// comes last to prove it was not
+	keys := []string{"foo/a", "foo/c", "foo/e", "fop", "foo/z"}
+	wantKeys := []string{"foo/a", "foo/c", "foo/e", "foo/z"}
+	for _, key := range keys {
+		if _, err := cli.Put(context.TODO(), key, "new"); err != nil {
+			cx.t.Fatal(err)
+		}
+	}
+
+	batches, err := watchEventBatches(wch, len(wantKeys))
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	var events []*clientv3.Event
+	for _, batch := range batches {
+		events = append(events, batch...)
+	}
+	if len(events) != len(wantKeys) {
+		cx.t.Fatalf("expected %d events, got %d", len(wantKeys), len(events))
+	}
+	for i, ev := range events {
+		if string(ev.Kv.Key) != wantKeys[i] || ev.Type != clientv3.EventTypePut {
+			cx.t.Fatalf("#%d: expected a PUT event on %q, got %v on %q", i, wantKeys[i], ev.Type, ev.Kv.Key)
+		}
+		if !ev.IsCreate() {
+			cx.t.Fatalf("#%d: expected %q to be created, got version %d", i, ev.Kv.Key, ev.Kv.Version)
+		}
+	}
+}
+
+func TestCtlV3WatchPrefixEvents(t *testing.T) { testCtl(t, watchPrefixEventsTest) }
+
+// watchPrefixEventsTest ensures watchPrefix delivers the puts under the
+// prefix in order, with the revisions they were written at.
+func watchPrefixEventsTest(cx ctlCtx) {
+	cli := newClient(cx.t, cx.epc.EndpointsV3(), cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	events, errc, err := watchPrefix(ctx, cli, "foo/")
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	var want []WatchEvent
+	for i := 0; i < 3; i++ {
+		key, val := fmt.Sprintf("foo/%d", i), fmt.Sprintf("bar%d", i)
+		resp, err := cli.Put(context.TODO(), key, val)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		want = append(want, WatchEvent{Type: mvccpb.PUT, Key: key, Value: val, Revision: resp.Header.Revision})
+	}
+	for i := range want {
+		select {
+		case ev := <-events:
+			if ev != want[i] {
+				cx.t.Fatalf("#%d: expected %+v, got %+v", i, want[i], ev)
+			}
+		case err = <-errc:
+			cx.t.Fatalf("#%d: watch failed (%v)", i, err)
+		case <-ctx.Done():
+			cx.t.Fatalf("#%d: timed out waiting for %+v", i, want[i])
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+// WatchEvent is a put or delete observed by watchPrefix.
+type WatchEvent struct {
+	Type     mvccpb.Event_EventType
+	Key      string
+	Value    string
+	Revision int64
+}
+
+// watchPrefix watches the keys under prefix from the current revision on,
+// returning once the watch is established. Events are delivered in order
+// until ctx is cancelled, when the event channel is closed. A watch error
+// is sent on the error channel and ends the watch.
+func watchPrefix(ctx context.Context, c *clientv3.Client, prefix string) (<-chan WatchEvent, <-chan error, error) {
+	wch := c.Watch(ctx, prefix, clientv3.WithPrefix(), clientv3.WithCreatedNotify())
+	wresp, ok := <-wch
+	if !ok {
+		return nil, nil, fmt.Errorf("watch on %q closed before it was created (%v)", prefix, ctx.Err())
+	}
+	if err := wresp.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan WatchEvent)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(events)
+		for wresp := range wch {
+			if err := wresp.Err(); err != nil {
+				errc <- err
+				return
+			}
+			for _, ev := range wresp.Events {
+				select {
+				case events <- WatchEvent{Type: ev.Type, Key: string(ev.Kv.Key), Value: string(ev.Kv.Value), Revision: ev.Kv.ModRevision}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, errc, nil
+}
+
 type kvExec struct {
 	key, val   string
 	execOutput string
@@ -67,6 +458,25 @@ func ctlV3Watch(cx ctlCtx, args []string, kvs ...kvExec) error {
 	return proc.Stop()
 }
 
+// ctlV3WatchFailNoLeader starts a watch against the given endpoint and
+// expects the watch to be canceled because the member has no leader.
+func ctlV3WatchFailNoLeader(cx ctlCtx, ep string, args []string) error {
+	cmdArgs := append(cx.prefixArgs([]string{ep}), "watch")
+	cmdArgs = append(cmdArgs, args...)
+
+	proc, err := e2e.SpawnCmd(cmdArgs, cx.envMap)
+	if err != nil {
+		return err
+	}
+	if _, err = proc.Expect(rpctypes.ErrNoLeader.Error()); err != nil {
+		return err
+	}
+	if _, err = proc.Expect("watch is canceled by the server"); err != nil {
+		return err
+	}
+	return proc.Close()
+}
+
 func ctlV3WatchFailPerm(cx ctlCtx, args []string) error {
 	cmdArgs := setupWatchArgs(cx, args)
 
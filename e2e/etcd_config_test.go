@@ -15,15 +15,19 @@
 package e2e
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/pkg/v3/expect"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"golang.org/x/sync/errgroup"
 )
 
 const exampleConfigFile = "../../etcd.conf.yml.sample"
@@ -91,6 +95,262 @@ func TestEtcdMultiPeer(t *testing.T) {
 	}
 }
 
+// TestEtcdMismatchedClusterID ensures a member started from the data dir of
+// another cluster is rejected by its peers rather than joining them. The other
+// cluster shares the member names and URLs, but not the initial cluster token
+// from which the cluster ID is derived.
+func TestEtcdMismatchedClusterID(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	other, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:  3,
+		InitialToken: "other",
+		KeepDataDir:  true,
+	})
+	if err != nil {
+		t.Fatalf("could not start the other etcd process cluster (%v)", err)
+	}
+	otherDataDir := other.Procs[0].Config().DataDirPath
+	if err = other.Close(); err != nil {
+		t.Fatalf("error closing the other etcd processes (%v)", err)
+	}
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 3,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+	before := memberIDsOf(t, epc)
+
+	member := epc.Procs[0]
+	if err = member.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	cfg := member.Config()
+	if err = os.RemoveAll(cfg.DataDirPath); err != nil {
+		t.Fatal(err)
+	}
+	if out, cerr := exec.Command("cp", "-a", otherDataDir, cfg.DataDirPath).CombinedOutput(); cerr != nil {
+		t.Fatalf("failed to stage the data dir of the other cluster (%v): %s", cerr, out)
+	}
+
+	proc, err := e2e.SpawnCmd(append([]string{cfg.ExecPath}, cfg.Args...), cfg.EnvVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Stop()
+	if _, err = proc.Expect("request sent was ignored by remote peer due to cluster ID mismatch"); err != nil {
+		t.Fatalf("expected peers to reject the member on its cluster ID (%v)", err)
+	}
+
+	// the remaining members keep quorum and their membership
+	cli := newClient(t, append(epc.Procs[1].EndpointsV3(), epc.Procs[2].EndpointsV3()...), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	if _, err = cli.Put(context.TODO(), "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, memberIDsOf(t, epc))
+}
+
+// TestEtcdInconsistentInitialCluster ensures a member whose --initial-cluster
+// omits one of its peers does not form a cluster of its own. Its member set,
+// and so its cluster ID, differs from the one of its peers, which reject it
+// while they keep quorum.
+func TestEtcdInconsistentInitialCluster(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	epc, err := e2e.InitEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 3,
+	})
+	if err != nil {
+		t.Fatalf("could not init etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	var initialCluster []string
+	for _, proc := range []e2e.EtcdProcess{epc.Procs[0], epc.Procs[2]} {
+		initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", proc.Config().Name, proc.Config().Purl.String()))
+	}
+	cfg := epc.Procs[0].Config()
+	cfg.SetInitialCluster(strings.Join(initialCluster, ","))
+
+	proc, err := e2e.SpawnCmd(append([]string{cfg.ExecPath}, cfg.Args...), cfg.EnvVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Stop()
+
+	g := errgroup.Group{}
+	for _, member := range epc.Procs[1:] {
+		g.Go(member.Start)
+	}
+	if err = g.Wait(); err != nil {
+		t.Fatalf("members with a consistent initial cluster did not start (%v)", err)
+	}
+	if _, err = proc.Expect("request sent was ignored by remote peer due to cluster ID mismatch"); err != nil {
+		t.Fatalf("expected peers to reject the member on its cluster ID (%v)", err)
+	}
+
+	cli := newClient(t, append(epc.Procs[1].EndpointsV3(), epc.Procs[2].EndpointsV3()...), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	if _, err = cli.Put(context.TODO(), "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, memberIDsOf(t, epc), 3)
+	assert.Error(t, epc.Procs[0].Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).Put("foo", "baz"),
+		"member with the inconsistent initial cluster serves requests")
+}
+
+// memberIDsOf returns the IDs of the members of epc, as listed by its second
+// member.
+func memberIDsOf(t *testing.T, epc *e2e.EtcdProcessCluster) []uint64 {
+	resp, err := epc.Procs[1].Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).MemberList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint64
+	for _, m := range resp.Members {
+		ids = append(ids, m.ID)
+	}
+	return ids
+}
+
+// TestEtcdStartupTimeout ensures a member that exits on an invalid flag, or
+// wedges waiting for an unreachable peer, fails the cluster start within the
+// startup timeout set by withStartupTimeout. Only the wedged member runs into
+// the timeout.
+func TestEtcdStartupTimeout(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	const startupTimeout = 5 * time.Second
+	for _, tc := range []struct {
+		name        string
+		setup       func(cfg *e2e.EtcdServerProcessConfig)
+		wantTimeout bool
+	}{
+		{
+			name: "unknown flag",
+			setup: func(cfg *e2e.EtcdServerProcessConfig) {
+				cfg.Args = append(cfg.Args, "--no-such-flag")
+			},
+		},
+		{
+			name: "unreachable peer",
+			setup: func(cfg *e2e.EtcdServerProcessConfig) {
+				cfg.SetInitialCluster(fmt.Sprintf("%s=%s,unreachable=http://localhost:1", cfg.Name, cfg.Purl.String()))
+			},
+			wantTimeout: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cx := getDefaultCtlCtx(t)
+			cx.applyOpts([]ctlOption{withCfg(*e2e.NewConfigNoTLS()), withStartupTimeout(startupTimeout)})
+			cx.applyClusterOpts(t)
+			epc, err := e2e.InitEtcdProcessCluster(t, &cx.cfg)
+			if err != nil {
+				t.Fatalf("could not init etcd process cluster (%v)", err)
+			}
+			tc.setup(epc.Procs[0].Config())
+
+			start := time.Now()
+			_, err = e2e.StartEtcdProcessCluster(t, epc, &cx.cfg)
+			took := time.Since(start)
+			if err == nil {
+				epc.Close()
+				t.Fatal("expected the cluster start to fail")
+			}
+			if took > startupTimeout+5*time.Second {
+				t.Fatalf("expected the cluster start to fail within %v, took %v (%v)", startupTimeout, took, err)
+			}
+			timedOut := strings.Contains(err.Error(), fmt.Sprintf("not ready within %v", startupTimeout))
+			if timedOut != tc.wantTimeout || (tc.wantTimeout && took < startupTimeout) {
+				t.Fatalf("expected the start to time out: %v, failed after %v (%v)", tc.wantTimeout, took, err)
+			}
+		})
+	}
+}
+
+// TestEtcdDuplicateMemberName ensures bootstrapping members that share a name
+// fails loudly. The initial cluster then lists both peer URLs under that name,
+// which no single member advertises.
+func TestEtcdDuplicateMemberName(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	epc, err := e2e.InitEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 3,
+		MemberNames: []string{"dup", "dup"},
+	})
+	if err != nil {
+		t.Fatalf("could not init etcd process cluster (%v)", err)
+	}
+	defer epc.Close()
+
+	cfg := epc.Procs[0].Config()
+	proc, err := e2e.SpawnCmd(append([]string{cfg.ExecPath}, cfg.Args...), cfg.EnvVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proc.Stop()
+	if _, err = proc.Expect("but missing from --initial-advertise-peer-urls"); err != nil {
+		t.Fatalf("expected bootstrap to fail on the duplicate member name (%v)", err)
+	}
+}
+
+// TestEtcdDiscoverySRVNameManual ensures two clusters bootstrapped from the
+// same SRV domain under different --discovery-srv-name values form
+// independently. It is manual-only and skipped in CI: etcd resolves the
+// records through the host resolver, so it needs a resolvable domain, given by
+// ETCD_E2E_DISCOVERY_SRV, publishing _etcd-server-alpha._tcp records for
+// localhost:20001, :20006 and :20011 and _etcd-server-beta._tcp records for
+// localhost:20101, :20106 and :20111.
+func TestEtcdDiscoverySRVNameManual(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	domain := os.Getenv("ETCD_E2E_DISCOVERY_SRV")
+	if domain == "" {
+		t.Skip("manual-only, ETCD_E2E_DISCOVERY_SRV is not set")
+	}
+
+	names := []string{"alpha", "beta"}
+	clusterIDs := make([]uint64, len(names))
+	for i, name := range names {
+		epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+			ClusterSize:      3,
+			BasePort:         e2e.EtcdProcessBasePort + 100*i,
+			InitialToken:     name,
+			DiscoverySRV:     domain,
+			DiscoverySRVName: name,
+		})
+		if err != nil {
+			t.Fatalf("could not start the %q cluster (%v)", name, err)
+		}
+		defer epc.Close()
+
+		resp, err := epc.Procs[0].Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).MemberList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want, peers []string
+		for _, proc := range epc.Procs {
+			want = append(want, proc.Config().Purl.String())
+		}
+		for _, m := range resp.Members {
+			peers = append(peers, m.PeerURLs...)
+		}
+		assert.ElementsMatch(t, want, peers, "cluster %q discovered foreign members", name)
+		clusterIDs[i] = resp.Header.ClusterId
+	}
+	assert.NotEqual(t, clusterIDs[0], clusterIDs[1])
+}
+
 // TestEtcdUnixPeers checks that etcd will boot with unix socket peers.
 func TestEtcdUnixPeers(t *testing.T) {
 	e2e.SkipInShortMode(t)
@@ -284,6 +544,70 @@ func TestEtcdPeerNameAuth(t *testing.T) {
 	}
 }
 
+// TestEtcdPeerSkipClientSANVerify checks that members whose peer certificates
+// do not match the address of the connecting peer only form a cluster when
+// --peer-skip-client-san-verification is set. Unix socket peers have no
+// address that any SAN could match.
+func TestEtcdPeerSkipClientSANVerify(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	t.Run("skip", func(t *testing.T) {
+		cfg := e2e.NewConfigPeerTLS()
+		cfg.BasePeerScheme = "unix"
+		cfg.PeerClientCertAuth = true
+		cfg.PeerSkipClientSANVerify = true
+		epc, err := e2e.NewEtcdProcessCluster(t, cfg)
+		if err != nil {
+			t.Fatalf("could not start etcd process cluster (%v)", err)
+		}
+		defer func() {
+			if errC := epc.Close(); errC != nil {
+				t.Fatalf("error closing etcd processes (%v)", errC)
+			}
+		}()
+
+		cc := e2e.NewEtcdctl(epc.EndpointsV3(), cfg.ClientTLS, cfg.IsClientAutoTLS, false)
+		if err = cc.Put("foo", "bar"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("verify", func(t *testing.T) {
+		cfg := e2e.NewConfigPeerTLS()
+		cfg.BasePeerScheme = "unix"
+		cfg.PeerClientCertAuth = true
+		epc, err := e2e.InitEtcdProcessCluster(t, cfg)
+		if err != nil {
+			t.Fatalf("could not init etcd process cluster (%v)", err)
+		}
+		defer func() {
+			if errC := epc.Close(); errC != nil {
+				t.Fatalf("error closing etcd processes (%v)", errC)
+			}
+		}()
+
+		procs := make([]*expect.ExpectProcess, len(epc.Procs))
+		defer func() {
+			for _, p := range procs {
+				if p != nil {
+					p.Stop()
+				}
+			}
+		}()
+		for i, proc := range epc.Procs {
+			pcfg := proc.Config()
+			if procs[i], err = e2e.SpawnCmd(append([]string{pcfg.ExecPath}, pcfg.Args...), pcfg.EnvVars); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, p := range procs {
+			if _, err = p.Expect("rejected connection"); err != nil {
+				t.Fatalf("expected members to reject peers failing SAN verification (%v)", err)
+			}
+		}
+	})
+}
+
 func TestGrpcproxyAndCommonName(t *testing.T) {
 	e2e.SkipInShortMode(t)
 
@@ -405,3 +729,126 @@ func TestEtcdTLSVersion(t *testing.T) {
 	assert.NoError(t, proc.Stop())
 
 }
+
+// TestEtcdFileRetention ensures etcd purges snapshot and WAL files beyond
+// --max-snapshots and --max-wals.
+func TestEtcdFileRetention(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	const maxSnapshots, maxWALs = 2, 2
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:   1,
+		SnapshotCount: 10,
+		MaxSnapshots:  maxSnapshots,
+		MaxWALs:       maxWALs,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	// 1MiB values fill a 64MiB WAL segment every ~64 puts, while the low
+	// snapshot count triggers a snapshot every 10 puts.
+	cli := newClient(t, epc.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	val := strings.Repeat("a", 1024*1024)
+	for i := 0; i < 64*(maxWALs+1); i++ {
+		if _, err = cli.Put(context.TODO(), fmt.Sprintf("key-%d", i%10), val); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dataDir := epc.Procs[0].Config().DataDirPath
+	var snaps, wals []string
+	// files are purged periodically, every 30 seconds
+	deadline := time.Now().Add(45 * time.Second)
+	for {
+		if snaps, err = e2e.SnapshotFiles(dataDir); err != nil {
+			t.Fatal(err)
+		}
+		if wals, err = e2e.WALFiles(dataDir); err != nil {
+			t.Fatal(err)
+		}
+		if len(snaps) <= maxSnapshots && len(wals) <= maxWALs {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected at most %d snapshots and %d WALs, got %q and %q", maxSnapshots, maxWALs, snaps, wals)
+		}
+		time.Sleep(time.Second)
+	}
+	if len(snaps) == 0 || len(wals) == 0 {
+		t.Fatalf("expected snapshot and WAL files to be retained, got %q and %q", snaps, wals)
+	}
+
+	// both limits must have been exceeded, with etcd purging the extra files
+	var purgedSnap, purgedWAL bool
+	for _, line := range epc.Procs[0].Logs().Lines() {
+		if !strings.Contains(line, `"msg":"purged"`) {
+			continue
+		}
+		purgedSnap = purgedSnap || strings.Contains(line, `.snap"`)
+		purgedWAL = purgedWAL || strings.Contains(line, `.wal"`)
+	}
+	if !purgedSnap || !purgedWAL {
+		t.Fatalf("expected etcd to purge both snapshot and WAL files, purged snapshots: %v, purged WALs: %v", purgedSnap, purgedWAL)
+	}
+}
+
+// TestEtcdReadOnlyDataDir ensures a member whose data dir is not writable
+// fails to start with a clear error, and starts again once it is writable.
+func TestEtcdReadOnlyDataDir(t *testing.T) {
+	e2e.BeforeTest(t)
+	if os.Geteuid() == 0 {
+		t.Skip("file permissions are not enforced for root")
+	}
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize: 3,
+		KeepDataDir: true,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	member := epc.Procs[0]
+	if err = member.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err = epc.MakeDataDirReadOnly(0); err != nil {
+		t.Fatal(err)
+	}
+	// restore the permissions so the data dir can be cleaned up on failure
+	defer epc.MakeDataDirWritable(0)
+
+	cfg := member.Config()
+	proc, err := e2e.SpawnCmd(append([]string{cfg.ExecPath}, cfg.Args...), cfg.EnvVars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = proc.Expect("permission denied"); err != nil {
+		t.Fatalf("expected start on a read-only data dir to fail with a permission error (%v)", err)
+	}
+	if err = proc.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = epc.MakeDataDirWritable(0); err != nil {
+		t.Fatal(err)
+	}
+	if err = member.Start(); err != nil {
+		t.Fatalf("member did not recover once its data dir was writable (%v)", err)
+	}
+	cli := newClient(t, member.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	if _, err = cli.Put(context.TODO(), "foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -21,9 +21,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/version"
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 // TestReleaseUpgrade ensures that changes to master branch does not affect
@@ -114,6 +114,55 @@ func TestReleaseUpgrade(t *testing.T) {
 	t.Log("TestReleaseUpgrade businessLogic DONE")
 }
 
+// TestMixedVersionCluster ensures members of the latest release and of the
+// current version form a cluster. The last release members start first, as
+// they would in a rolling upgrade.
+func TestMixedVersionCluster(t *testing.T) {
+	lastReleaseBinary := e2e.BinDir + "/etcd-last-release"
+	if !fileutil.Exist(lastReleaseBinary) {
+		t.Skipf("%q does not exist", lastReleaseBinary)
+	}
+
+	e2e.BeforeTest(t)
+
+	cfg := e2e.NewConfigNoTLS()
+	cfg.ExecPath = e2e.BinDir + "/etcd"
+	cfg.ExecPathOverrides = map[int]string{0: lastReleaseBinary, 1: lastReleaseBinary}
+	cfg.RollingStart = true
+	cfg.BasePeerScheme = "unix" // to avoid port conflict
+
+	epc, err := e2e.NewEtcdProcessCluster(t, cfg)
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	cx := ctlCtx{
+		t:           t,
+		cfg:         *cfg,
+		dialTimeout: 7 * time.Second,
+		quorum:      true,
+		epc:         epc,
+	}
+	if err = waitClusterHealthy(cx, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	for i, proc := range epc.Procs {
+		if err = ctlV3Put(cx, fmt.Sprintf("foo%d", i), "bar", ""); err != nil {
+			t.Fatalf("#%d: ctlV3Put error (%v)", i, err)
+		}
+		pcx := cx
+		pcx.epc = &e2e.EtcdProcessCluster{Cfg: epc.Cfg, Procs: []e2e.EtcdProcess{proc}}
+		if err = ctlV3Get(pcx, []string{fmt.Sprintf("foo%d", i)}, kv{key: fmt.Sprintf("foo%d", i), val: "bar"}); err != nil {
+			t.Fatalf("#%d: ctlV3Get from %s error (%v)", i, proc.Config().ExecPath, err)
+		}
+	}
+}
+
 func TestReleaseUpgradeWithRestart(t *testing.T) {
 	lastReleaseBinary := e2e.BinDir + "/etcd-last-release"
 	if !fileutil.Exist(lastReleaseBinary) {
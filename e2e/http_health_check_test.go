@@ -29,9 +29,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/server/v3/storage/mvcc/testutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 const (
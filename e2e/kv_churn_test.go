@@ -0,0 +1,140 @@
+// Copyright 2023 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"golang.org/x/sync/errgroup"
+)
+
+// TestV3KeyChurn creates and deletes a single key from several clients at
+// once and ensures its tombstones and version accounting stay coherent.
+func TestV3KeyChurn(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	const (
+		key         = "churn"
+		clientCount = 5
+		iterations  = 200
+	)
+
+	cfg := e2e.NewConfigNoTLS()
+	epc, err := e2e.NewEtcdProcessCluster(t, cfg)
+	require.NoError(t, err)
+	defer epc.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	var clients []*clientv3.Client
+	for i := 0; i < clientCount; i++ {
+		// pin each client to one member so writes race through every member
+		ep := epc.Procs[i%len(epc.Procs)].EndpointsV3()
+		clients = append(clients, newClient(t, ep, cfg.ClientTLS, cfg.IsClientAutoTLS))
+	}
+
+	startResp, err := clients[0].Get(ctx, key)
+	require.NoError(t, err)
+	wch := clients[0].Watch(ctx, key, clientv3.WithRev(startResp.Header.Revision+1))
+
+	creates, deletes, err := churnKey(ctx, clients, key, iterations)
+	require.NoError(t, err)
+	t.Logf("created %q %d times and deleted it %d times", key, creates, deletes)
+
+	resp, err := clients[0].Get(ctx, key)
+	require.NoError(t, err)
+	switch creates - deletes {
+	case 0:
+		require.Empty(t, resp.Kvs)
+	case 1:
+		require.Len(t, resp.Kvs, 1)
+		require.Equal(t, int64(1), resp.Kvs[0].Version)
+		require.Equal(t, resp.Kvs[0].CreateRevision, resp.Kvs[0].ModRevision)
+	default:
+		t.Fatalf("expected creates to exceed deletes by at most one, got %d creates and %d deletes", creates, deletes)
+	}
+
+	var events []*clientv3.Event
+	for len(events) < creates+deletes {
+		select {
+		case wresp, ok := <-wch:
+			require.True(t, ok, "watch closed after %d of %d events", len(events), creates+deletes)
+			require.NoError(t, wresp.Err())
+			events = append(events, wresp.Events...)
+		case <-ctx.Done():
+			t.Fatalf("timed out after %d of %d events", len(events), creates+deletes)
+		}
+	}
+	require.Len(t, events, creates+deletes)
+
+	prevRev := startResp.Header.Revision
+	for i, ev := range events {
+		require.Greater(t, ev.Kv.ModRevision, prevRev, "event %d revision", i)
+		prevRev = ev.Kv.ModRevision
+		if i%2 == 0 {
+			require.Equal(t, mvccpb.PUT, ev.Type, "event %d type", i)
+			require.Equal(t, int64(1), ev.Kv.Version, "event %d version", i)
+			require.Equal(t, ev.Kv.ModRevision, ev.Kv.CreateRevision, "event %d create revision", i)
+		} else {
+			require.Equal(t, mvccpb.DELETE, ev.Type, "event %d type", i)
+		}
+	}
+	require.Equal(t, resp.Header.Revision, prevRev)
+}
+
+// churnKey has every client alternately try to create and delete key until
+// it has made iterations attempts. A create only succeeds on an absent key
+// and a delete only on a present one, so the successful operations alternate.
+func churnKey(ctx context.Context, clients []*clientv3.Client, key string, iterations int) (creates, deletes int, err error) {
+	var created, deleted int64
+	g, ctx := errgroup.WithContext(ctx)
+	for i, c := range clients {
+		i, c := i, c
+		g.Go(func() error {
+			for j := 0; j < iterations; j++ {
+				absent := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+				if (i+j)%2 == 0 {
+					resp, err := c.Txn(ctx).If(absent).Then(clientv3.OpPut(key, fmt.Sprintf("%d-%d", i, j))).Commit()
+					if err != nil {
+						return err
+					}
+					if resp.Succeeded {
+						atomic.AddInt64(&created, 1)
+					}
+				} else {
+					resp, err := c.Txn(ctx).If(absent).Else(clientv3.OpDelete(key)).Commit()
+					if err != nil {
+						return err
+					}
+					if !resp.Succeeded {
+						atomic.AddInt64(&deleted, 1)
+					}
+				}
+			}
+			return nil
+		})
+	}
+	err = g.Wait()
+	return int(created), int(deleted), err
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// These tests are performance sensitive, addition of cluster proxy makes them unstable.
+//go:build !cluster_proxy
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	// defaultMaxRSSGrowthPerKey bounds the resident memory a small key may
+	// add, covering its index entry and cached backend pages.
+	defaultMaxRSSGrowthPerKey = 1024
+	defaultMaxPointGetLatency = 50 * time.Millisecond
+	pointGetCount             = 1000
+)
+
+// TestV3PutManyKeys fills a member with many small keys and ensures ranges
+// still count them all, memory grows in proportion and point lookups stay fast.
+// It runs with 100K keys, and also with ETCD_E2E_MANY_KEYS keys when that is
+// set outside of short mode. ETCD_E2E_MANY_KEYS_MAX_RSS_PER_KEY (bytes) and
+// ETCD_E2E_MANY_KEYS_MAX_P99 (a duration) override the thresholds for slower
+// runners.
+func TestV3PutManyKeys(t *testing.T) {
+	e2e.BeforeTest(t)
+	maxRSSGrowthPerKey := intFromEnv(t, "ETCD_E2E_MANY_KEYS_MAX_RSS_PER_KEY", defaultMaxRSSGrowthPerKey)
+	maxPointGetLatency := durationFromEnv(t, "ETCD_E2E_MANY_KEYS_MAX_P99", defaultMaxPointGetLatency)
+	keyCounts := []int{100 * Kilo}
+	if n := intFromEnv(t, "ETCD_E2E_MANY_KEYS", 0); n > 0 && !testing.Short() {
+		keyCounts = append(keyCounts, n)
+	}
+	for _, keyCount := range keyCounts {
+		keyCount := keyCount
+		t.Run(strconv.Itoa(keyCount), func(t *testing.T) {
+			cfg := e2e.EtcdProcessClusterConfig{ClusterSize: 1}
+			clus, err := e2e.NewEtcdProcessCluster(t, &cfg)
+			require.NoError(t, err)
+			defer clus.Close()
+			c := newClient(t, clus.EndpointsV3(), cfg.ClientTLS, cfg.IsClientAutoTLS)
+			metricsURL := clus.Procs[0].EndpointsHTTP()[0]
+
+			rssBefore, err := fetchMetricValue(t, metricsURL, "process_resident_memory_bytes", cfg.ClientTLS)
+			require.NoError(t, err)
+			require.NoError(t, fillEtcdWithDataCustom(context.Background(), c, fillEtcdWithDataOpts{
+				KeyCount:  keyCount,
+				KeyPrefix: "key-",
+				ValueSize: 10,
+			}))
+
+			resp, err := c.Get(context.Background(), "key-", clientv3.WithPrefix(), clientv3.WithCountOnly())
+			require.NoError(t, err)
+			require.Equal(t, int64(keyCount), resp.Count)
+
+			rssAfter, err := fetchMetricValue(t, metricsURL, "process_resident_memory_bytes", cfg.ClientTLS)
+			require.NoError(t, err)
+			growth := rssAfter - rssBefore
+			t.Logf("resident memory grew by %.0f bytes for %d keys", growth, keyCount)
+			require.Less(t, growth, float64(maxRSSGrowthPerKey*keyCount))
+
+			latencies := make([]time.Duration, pointGetCount)
+			for i := range latencies {
+				key := fmt.Sprintf("key-%d", rand.Intn(keyCount))
+				start := time.Now()
+				resp, err := c.Get(context.Background(), key)
+				latencies[i] = time.Since(start)
+				require.NoError(t, err)
+				require.Len(t, resp.Kvs, 1)
+			}
+			sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+			p99 := latencies[len(latencies)*99/100]
+			require.Less(t, p99, maxPointGetLatency, "p99 point get latency %v", p99)
+		})
+	}
+}
+
+// fetchMetricValue returns the value of the unlabeled gauge or counter name
+// served at the /metrics endpoint.
+func fetchMetricValue(t *testing.T, endpoint, name string, connType e2e.ClientConnType) (float64, error) {
+	metricFile := filepath.Join(t.TempDir(), "metrics")
+	req := e2e.CURLReq{Endpoint: "/metrics", Timeout: 5, OutputFile: metricFile}
+	if _, err := curl(endpoint, "GET", req, connType); err != nil {
+		return 0, err
+	}
+	metrics, err := parseMetricsFile(metricFile)
+	if err != nil {
+		return 0, err
+	}
+	v, ok := metrics[name]
+	if !ok {
+		return 0, fmt.Errorf("metric %q not found", name)
+	}
+	return v, nil
+}
+
+// intFromEnv returns the integer value of the environment variable name, or
+// def if it is unset.
+func intFromEnv(t *testing.T, name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	require.NoErrorf(t, err, "invalid %s", name)
+	return n
+}
+
+// durationFromEnv returns the duration value of the environment variable
+// name, or def if it is unset.
+func durationFromEnv(t *testing.T, name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	require.NoErrorf(t, err, "invalid %s", name)
+	return d
+}
@@ -8,8 +8,8 @@ import (
 	"os"
 	"testing"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestMain(m *testing.M) {
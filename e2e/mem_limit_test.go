@@ -0,0 +1,104 @@
+// Copyright 2023 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cluster_proxy
+
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// TestV3PutUnderMemoryLimit drives a member running under a tight GOMEMLIMIT
+// with large puts and full ranges, ensuring the limit reaches the etcd
+// process, and that the member stays available and the garbage collector
+// keeps its heap under the limit.
+func TestV3PutUnderMemoryLimit(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	const (
+		memLimit  = 128 << 20
+		keyCount  = 50
+		valueSize = 512 * 1024
+		rounds    = 10
+	)
+	cfg := e2e.EtcdProcessClusterConfig{ClusterSize: 1, GoMemLimit: "128MiB"}
+	clus, err := e2e.NewEtcdProcessCluster(t, &cfg)
+	require.NoError(t, err)
+	defer clus.Close()
+	env, err := processEnv(clus.Procs[0].Config().DataDirPath)
+	require.NoError(t, err)
+	require.Equal(t, cfg.GoMemLimit, env["GOMEMLIMIT"], "GOMEMLIMIT did not reach the etcd process")
+
+	c := newClient(t, clus.EndpointsV3(), cfg.ClientTLS, cfg.IsClientAutoTLS)
+	metricsURL := clus.Procs[0].EndpointsHTTP()[0]
+
+	for i := 0; i < rounds; i++ {
+		require.NoError(t, fillEtcdWithDataCustom(context.Background(), c, fillEtcdWithDataOpts{
+			KeyCount:  keyCount,
+			KeyPrefix: "key-",
+			ValueSize: valueSize,
+		}))
+		resp, err := c.Get(context.Background(), "key-", clientv3.WithPrefix())
+		require.NoError(t, err)
+		require.Len(t, resp.Kvs, keyCount)
+
+		heapInuse, err := fetchMetricValue(t, metricsURL, "go_memstats_heap_inuse_bytes", cfg.ClientTLS)
+		require.NoError(t, err)
+		require.Less(t, heapInuse, float64(memLimit), "heap grew past GOMEMLIMIT in round %d", i)
+	}
+	require.True(t, clus.Procs[0].IsRunning(), "member exited under memory pressure")
+	_, err = c.Put(context.Background(), "foo", "bar")
+	require.NoError(t, err)
+}
+
+// processEnv returns the environment of the running process started with
+// --data-dir dataDir, as read from /proc.
+func processEnv(dataDir string) (map[string]string, error) {
+	cmdlines, err := filepath.Glob("/proc/[0-9]*/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	for _, cmdlineFile := range cmdlines {
+		cmdline, err := os.ReadFile(cmdlineFile)
+		if err != nil {
+			// the process exited meanwhile
+			continue
+		}
+		if !strings.Contains(string(cmdline), "\x00--data-dir\x00"+dataDir+"\x00") {
+			continue
+		}
+		environ, err := os.ReadFile(filepath.Join(filepath.Dir(cmdlineFile), "environ"))
+		if err != nil {
+			return nil, err
+		}
+		env := make(map[string]string)
+		for _, kv := range strings.Split(string(environ), "\x00") {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				env[k] = v
+			}
+		}
+		return env, nil
+	}
+	return nil, fmt.Errorf("no process runs with --data-dir %s", dataDir)
+}
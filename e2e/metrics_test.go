@@ -18,8 +18,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/version"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestV3MetricsSecure(t *testing.T) {
@@ -36,6 +36,38 @@ func TestV3MetricsInsecure(t *testing.T) {
 	testCtl(t, metricsTest)
 }
 
+func TestV3MetricsScrapeInsecure(t *testing.T) {
+	testCtl(t, metricsScrapeTest, withCfg(*e2e.NewConfigNoTLS()))
+}
+
+func TestV3MetricsScrapeSecure(t *testing.T) {
+	testCtl(t, metricsScrapeTest, withCfg(*e2e.NewConfigClientTLS()))
+}
+
+// metricsScrapeTest ensures a committed put is reflected in the scraped
+// proposal metrics.
+func metricsScrapeTest(cx ctlCtx) {
+	const name = "etcd_server_proposals_committed_total"
+
+	before, err := scrapeMetrics(cx.epc, 0)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if _, ok := before[name]; !ok {
+		cx.t.Fatalf("metric %q not found", name)
+	}
+	if err = ctlV3Put(cx, "k", "v", ""); err != nil {
+		cx.t.Fatal(err)
+	}
+	after, err := scrapeMetrics(cx.epc, 0)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if after[name] <= before[name] {
+		cx.t.Fatalf("expected %s to increase after a put, got %v -> %v", name, before[name], after[name])
+	}
+}
+
 func metricsTest(cx ctlCtx) {
 	if err := ctlV3Put(cx, "k", "v", ""); err != nil {
 		cx.t.Fatal(err)
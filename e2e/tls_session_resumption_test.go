@@ -0,0 +1,109 @@
+// Copyright 2023 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+)
+
+func TestV3TLSSessionResumptionRefusedAfterRestart(t *testing.T) {
+	testCtl(t, tlsSessionResumptionRefusedAfterRestartTest, withCfg(*e2e.NewConfigClientTLS()))
+}
+
+// tlsSessionResumptionRefusedAfterRestartTest ensures a client caching TLS
+// sessions resumes its session on a new connection, and that a restarted
+// member refuses to resume it, as the restart generates new session ticket
+// keys. The member restarts with another certificate, which the full handshake
+// then presents. Rotating the certificate alone would not refuse the session:
+// the member does not tie its tickets to a certificate.
+func tlsSessionResumptionRefusedAfterRestartTest(cx ctlCtx) {
+	caCert, err := os.ReadFile(e2e.CaPath)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caCert) {
+		cx.t.Fatalf("failed to parse %s", e2e.CaPath)
+	}
+	// --trusted-ca-file makes the member require a client certificate
+	cert, err := tls.LoadX509KeyPair(e2e.CertPath, e2e.PrivateKeyPath)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	hc := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:            pool,
+			Certificates:       []tls.Certificate{cert},
+			ClientSessionCache: tls.NewLRUClientSessionCache(8),
+		},
+		// every request dials and handshakes a new connection
+		DisableKeepAlives: true,
+	}}
+	proc := cx.epc.Procs[0]
+	url := proc.EndpointsV3()[0] + "/health"
+
+	for i, wantResume := range []bool{false, true} {
+		st, err := tlsGetConnectionState(hc, url)
+		if err != nil {
+			cx.t.Fatal(err)
+		}
+		if st.DidResume != wantResume {
+			cx.t.Fatalf("#%d: expected the session resumed %v, got %v", i, wantResume, st.DidResume)
+		}
+	}
+
+	proc.Config().Args = e2e.PatchArgs(proc.Config().Args, "cert-file", e2e.CertPath2)
+	proc.Config().Args = e2e.PatchArgs(proc.Config().Args, "key-file", e2e.PrivateKeyPath2)
+	if err = proc.Restart(); err != nil {
+		cx.t.Fatal(err)
+	}
+	st, err := tlsGetConnectionState(hc, url)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if st.DidResume {
+		cx.t.Fatal("expected the restarted member to refuse resuming the session")
+	}
+	// the full handshake picks up the certificate of the restart
+	if cn := st.PeerCertificates[0].Subject.CommonName; cn != "example2.com" {
+		cx.t.Fatalf("expected the rotated certificate for example2.com, got %q", cn)
+	}
+}
+
+// tlsGetConnectionState GETs url and returns the state of the TLS connection
+// the response was received on. The body is read to the end, so session
+// tickets the server sends after the handshake are cached.
+func tlsGetConnectionState(hc *http.Client, url string) (*tls.ConnectionState, error) {
+	resp, err := hc.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if _, err = io.ReadAll(resp.Body); err != nil {
+		return nil, err
+	}
+	if resp.TLS == nil {
+		return nil, fmt.Errorf("expected a TLS connection to %s", url)
+	}
+	return resp.TLS, nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2022 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package e2e
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	traceservice "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/grpc"
+)
+
+// TestDistributedTracing ensures that etcd processes started with distributed
+// tracing enabled export spans for KV requests to the configured collector.
+func TestDistributedTracing(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	// set up an in-process OTLP trace collector
+	listener, err := net.Listen("tcp", "localhost:")
+	require.NoError(t, err)
+
+	collector := &traceCollector{spans: make(chan string, 1000)}
+	srv := grpc.NewServer()
+	traceservice.RegisterTraceServiceServer(srv, collector)
+	go srv.Serve(listener)
+	defer srv.Stop()
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:                    1,
+		EnableDistributedTracing:       true,
+		DistributedTracingAddress:      listener.Addr().String(),
+		DistributedTracingSamplingRate: 1000000,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	cli := newClient(t, epc.EndpointsV3(), epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS)
+	_, err = cli.Put(context.TODO(), "foo", "bar")
+	require.NoError(t, err)
+	_, err = cli.Get(context.TODO(), "foo")
+	require.NoError(t, err)
+
+	// spans are exported in batches, wait until both requests are seen
+	want := map[string]bool{"etcdserverpb.KV/Put": false, "etcdserverpb.KV/Range": false}
+	timeout := time.After(30 * time.Second)
+	for missing := len(want); missing > 0; {
+		select {
+		case name := <-collector.spans:
+			if seen, ok := want[name]; ok && !seen {
+				want[name] = true
+				missing--
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for spans, got %v", want)
+		}
+	}
+}
+
+// traceCollector implements TraceServiceServer and forwards the names of
+// received spans.
+type traceCollector struct {
+	traceservice.UnimplementedTraceServiceServer
+	spans chan string
+}
+
+func (c *traceCollector) Export(ctx context.Context, req *traceservice.ExportTraceServiceRequest) (*traceservice.ExportTraceServiceResponse, error) {
+	for _, resourceSpans := range req.GetResourceSpans() {
+		for _, scoped := range resourceSpans.GetScopeSpans() {
+			for _, span := range scoped.GetSpans() {
+				select {
+				case c.spans <- span.GetName():
+				default:
+				}
+			}
+		}
+	}
+	return &traceservice.ExportTraceServiceResponse{}, nil
+}
@@ -16,13 +16,24 @@ package e2e
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/prometheus/common/expfmt"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	clientv2 "go.etcd.io/etcd/client/v2"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 	"go.etcd.io/etcd/tests/v3/integration"
 	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
@@ -33,6 +44,8 @@ import (
 	"go.etcd.io/etcd/pkg/v3/stringutil"
 )
 
+// newClient returns a client of the given endpoints, closed when the test
+// ends. Endpoints may be unix(s):// sockets, which clientv3 dials natively.
 func newClient(t *testing.T, entpoints []string, connType e2e.ClientConnType, isAutoTLS bool) *clientv3.Client {
 	tlscfg, err := tlsInfo(t, connType, isAutoTLS)
 	if err != nil {
@@ -95,17 +108,130 @@ func tlsInfo(t testing.TB, connType e2e.ClientConnType, isAutoTLS bool) (*transp
 	}
 }
 
+// clientCA is a throwaway certificate authority issuing client certificates
+// with a chosen CommonName.
+type clientCA struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certPath string
+}
+
+func newClientCA(t testing.TB) *clientCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "e2e client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(t.TempDir(), "client-ca.crt")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	return &clientCA{cert: cert, key: key, certPath: certPath}
+}
+
+// issue returns the paths of a new client certificate for cn and its key.
+func (ca *clientCA) issue(t testing.TB, cn string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certPath, keyPath = filepath.Join(dir, cn+".crt"), filepath.Join(dir, cn+".key")
+	writePEM(t, certPath, "CERTIFICATE", der)
+	writePEM(t, keyPath, "EC PRIVATE KEY", keyDER)
+	return certPath, keyPath
+}
+
+// bundle returns the path of a CA file trusting both the CA at caPath and ca.
+func (ca *clientCA) bundle(t testing.TB, caPath string) string {
+	var b []byte
+	for _, p := range []string{caPath, ca.certPath} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b = append(b, data...)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "trusted-ca.crt")
+	if err := os.WriteFile(bundlePath, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return bundlePath
+}
+
+func writePEM(t testing.TB, fpath, blockType string, der []byte) {
+	if err := os.WriteFile(fpath, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func fillEtcdWithData(ctx context.Context, c *clientv3.Client, dbSize int) error {
-	g := errgroup.Group{}
-	concurrency := 10
 	keyCount := 100
-	keysPerRoutine := keyCount / concurrency
-	valueSize := dbSize / keyCount
+	return fillEtcdWithDataCustom(ctx, c, fillEtcdWithDataOpts{
+		KeyCount:  keyCount,
+		ValueSize: dbSize / keyCount,
+	})
+}
+
+// fillEtcdWithDataOpts configures fillEtcdWithDataCustom.
+type fillEtcdWithDataOpts struct {
+	// KeyCount keys are put, named KeyPrefix followed by their index.
+	KeyCount  int
+	KeyPrefix string
+	// ValueSize is the size in bytes of the random value of every key.
+	ValueSize int
+	// Concurrency is the number of concurrent writers, default is 10.
+	Concurrency int
+}
+
+// fillEtcdWithDataCustom puts opts.KeyCount keys, named opts.KeyPrefix
+// followed by their index, each holding a random value of opts.ValueSize
+// bytes.
+func fillEtcdWithDataCustom(ctx context.Context, c *clientv3.Client, opts fillEtcdWithDataOpts) error {
+	g := errgroup.Group{}
+	concurrency := opts.Concurrency
+	if concurrency == 0 {
+		concurrency = 10
+	}
 	for i := 0; i < concurrency; i++ {
 		i := i
 		g.Go(func() error {
-			for j := 0; j < keysPerRoutine; j++ {
-				_, err := c.Put(ctx, fmt.Sprintf("%d", i*keysPerRoutine+j), stringutil.RandString(uint(valueSize)))
+			for j := i; j < opts.KeyCount; j += concurrency {
+				_, err := c.Put(ctx, fmt.Sprintf("%s%d", opts.KeyPrefix, j), stringutil.RandString(uint(opts.ValueSize)))
 				if err != nil {
 					return err
 				}
@@ -129,6 +255,13 @@ func getMemberIdByName(ctx context.Context, c *e2e.Etcdctl, name string) (id uin
 	return 0, false, nil
 }
 
+// etcdSupportsFlag reports whether the etcd binary under test lists flag in
+// its help, for features newer than some of the binaries tests run against.
+func etcdSupportsFlag(flag string) bool {
+	out, _ := exec.Command(e2e.BinPath, "--help").CombinedOutput()
+	return strings.Contains(string(out), flag)
+}
+
 // Different implementations here since 3.5 e2e test framework does not have "initial-cluster-state" as a default argument
 // Append new flag if not exist, otherwise replace the value
 func patchArgs(args []string, flag, newValue string) []string {
@@ -141,3 +274,65 @@ func patchArgs(args []string, flag, newValue string) []string {
 	args = append(args, fmt.Sprintf("--%s=%s", flag, newValue))
 	return args
 }
+
+// scrapeMetrics fetches /metrics from the client URL of the member at
+// memberIdx, using the same TLS arguments as e2e.CURLGet. Labeled samples
+// are keyed as name{label="value",...}; histograms and summaries contribute
+// their _sum and _count samples.
+func scrapeMetrics(epc *e2e.EtcdProcessCluster, memberIdx int) (map[string]float64, error) {
+	dir, err := os.MkdirTemp("", "metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	metricFile := filepath.Join(dir, "metrics")
+	req := e2e.CURLReq{Endpoint: "/metrics", Timeout: 5, OutputFile: metricFile}
+	args := e2e.CURLPrefixArgs(epc.Procs[memberIdx].Config().Acurl, epc.Cfg.ClientTLS, !epc.Cfg.NoCN, "GET", req)
+	if _, err = e2e.RunUtilCompletion(args, nil); err != nil {
+		return nil, err
+	}
+	return parseMetricsFile(metricFile)
+}
+
+// parseMetricsFile parses a file in the Prometheus text exposition format
+// into a flat map from sample name, with its labels folded in, to value.
+func parseMetricsFile(metricFile string) (map[string]float64, error) {
+	rawData, err := os.ReadFile(metricFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the metric: %w", err)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(strings.NewReader(strings.ReplaceAll(string(rawData), "\r\n", "\n")))
+	if err != nil {
+		return nil, err
+	}
+	metrics := make(map[string]float64)
+	for name, family := range families {
+		for _, m := range family.Metric {
+			var labels []string
+			for _, l := range m.Label {
+				labels = append(labels, fmt.Sprintf("%s=%q", l.GetName(), l.GetValue()))
+			}
+			suffix := ""
+			if len(labels) > 0 {
+				suffix = "{" + strings.Join(labels, ",") + "}"
+			}
+			switch {
+			case m.Gauge != nil:
+				metrics[name+suffix] = m.Gauge.GetValue()
+			case m.Counter != nil:
+				metrics[name+suffix] = m.Counter.GetValue()
+			case m.Untyped != nil:
+				metrics[name+suffix] = m.Untyped.GetValue()
+			case m.Histogram != nil:
+				metrics[name+"_sum"+suffix] = m.Histogram.GetSampleSum()
+				metrics[name+"_count"+suffix] = float64(m.Histogram.GetSampleCount())
+			case m.Summary != nil:
+				metrics[name+"_sum"+suffix] = m.Summary.GetSampleSum()
+				metrics[name+"_count"+suffix] = float64(m.Summary.GetSampleCount())
+			}
+		}
+	}
+	return metrics, nil
+}
@@ -18,7 +18,7 @@ import (
 	"strings"
 	"testing"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestV2CurlNoTLS(t *testing.T)      { testCurlPutGet(t, e2e.NewConfigNoTLS()) }
@@ -21,8 +21,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/version"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestV3CurlCipherSuitesValid(t *testing.T)    { testV3CurlCipherSuites(t, true) }
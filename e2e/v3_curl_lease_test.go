@@ -18,8 +18,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 func TestV3CurlLeaseGrantNoTLS(t *testing.T) {
@@ -23,9 +23,9 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 )
 
 // TestV3Curl_MaxStreams_BelowLimit_NoTLS_Small tests no TLS
@@ -20,14 +20,15 @@ import (
 	"fmt"
 	"path"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 	"go.etcd.io/etcd/api/v3/authpb"
 	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	"go.etcd.io/etcd/client/pkg/v3/testutil"
 	epb "go.etcd.io/etcd/server/v3/etcdserver/api/v3election/v3electionpb"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
 
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 )
@@ -80,6 +81,29 @@ func TestV3CurlAuthClientTLSCertAuth(t *testing.T) {
 		testCtl(t, testV3CurlAuth, withApiPrefix(p), withCfg(*e2e.NewConfigClientTLSCertAuthWithNoCN()))
 	}
 }
+func TestV3CurlMaintenanceStatus(t *testing.T) {
+	testCtl(t, testV3CurlMaintenanceStatus, withQuorum(), withCfg(*e2e.NewConfigNoTLS()))
+}
+
+func TestV3CurlMaintenanceDefragment(t *testing.T) {
+	testCtl(t, testV3CurlMaintenanceDefragment, withQuorum(), withCfg(*e2e.NewConfigNoTLS()))
+}
+
+func TestV3CurlGRPCGatewayDisabled(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.NoGRPCGateway = true
+	for _, p := range apiPrefix {
+		testCtl(t, testV3CurlGRPCGatewayDisabled, withApiPrefix(p), withCfg(*cfg))
+	}
+}
+
+func TestV3CurlCORS(t *testing.T) {
+	cfg := e2e.NewConfigNoTLS()
+	cfg.CorsOrigins = "http://allowed.example.com"
+	for _, p := range apiPrefix {
+		testCtl(t, testV3CurlCORS, withApiPrefix(p), withCfg(*cfg))
+	}
+}
 
 func testV3CurlPutGet(cx ctlCtx) {
 	var (
@@ -118,6 +142,139 @@ func testV3CurlPutGet(cx ctlCtx) {
 	}
 }
 
+// testV3CurlMaintenanceStatus ensures the status of every member served by the
+// gRPC gateway agrees with the one served over gRPC.
+func testV3CurlMaintenanceStatus(cx ctlCtx) {
+	for i, proc := range cx.epc.Procs {
+		hresp, err := cx.epc.V3HTTPStatus(i)
+		if err != nil {
+			cx.t.Fatalf("failed testV3CurlMaintenanceStatus status with curl (%v)", err)
+		}
+		gresp, err := proc.Etcdctl(cx.epc.Cfg.ClientTLS, cx.epc.Cfg.IsClientAutoTLS, false).Status()
+		if err != nil {
+			cx.t.Fatalf("failed testV3CurlMaintenanceStatus status with etcdctl (%v)", err)
+		}
+		if len(gresp) != 1 {
+			cx.t.Fatalf("expected a single endpoint status, got %d", len(gresp))
+		}
+		want := gresp[0]
+		if hresp.Header.ClusterId != want.Header.ClusterId || hresp.Header.MemberId != want.Header.MemberId {
+			cx.t.Fatalf("expected member %x of cluster %x, got member %x of cluster %x", want.Header.MemberId, want.Header.ClusterId, hresp.Header.MemberId, hresp.Header.ClusterId)
+		}
+		if hresp.Version != want.Version || hresp.Leader != want.Leader || hresp.RaftTerm != want.RaftTerm {
+			cx.t.Fatalf("expected version %s, leader %x at term %d, got version %s, leader %x at term %d", want.Version, want.Leader, want.RaftTerm, hresp.Version, hresp.Leader, hresp.RaftTerm)
+		}
+	}
+}
+
+// testV3CurlMaintenanceDefragment defragments one member through the gRPC
+// gateway and another with etcdctl, ensuring both reclaim the same space.
+func testV3CurlMaintenanceDefragment(cx ctlCtx) {
+	fillCompactedHistory(cx)
+
+	httpEP, ctlEP := cx.epc.Procs[0].EndpointsV3()[0], cx.epc.Procs[1].EndpointsV3()[0]
+	httpBefore, _, err := dbSize(cx, httpEP)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	ctlBefore, _, err := dbSize(cx, ctlEP)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	if err = cx.epc.V3HTTPDefragment(0); err != nil {
+		cx.t.Fatalf("failed testV3CurlMaintenanceDefragment defrag with curl (%v)", err)
+	}
+	if err = ctlV3OnlineDefragWithEndpoints(cx, []string{ctlEP}); err != nil {
+		cx.t.Fatalf("failed testV3CurlMaintenanceDefragment defrag with etcdctl (%v)", err)
+	}
+
+	httpAfter, _, err := dbSize(cx, httpEP)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	ctlAfter, _, err := dbSize(cx, ctlEP)
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+	if httpAfter >= httpBefore {
+		cx.t.Fatalf("expected the gateway defrag to shrink the db from %d, got %d", httpBefore, httpAfter)
+	}
+	if ctlAfter >= ctlBefore {
+		cx.t.Fatalf("expected the etcdctl defrag to shrink the db from %d, got %d", ctlBefore, ctlAfter)
+	}
+	// both members hold the same data, their defragmented backends only
+	// differ by a few pages of member local metadata
+	diff := httpAfter - ctlAfter
+	if diff < 0 {
+		diff = -diff
+	}
+	if diff > ctlAfter/10 {
+		cx.t.Fatalf("expected the gateway and etcdctl defrags to reclaim the same space, got db sizes %d and %d", httpAfter, ctlAfter)
+	}
+}
+
+// testV3CurlCORS ensures the gateway answers with an Access-Control-Allow-Origin
+// header only to the origins allowed by --cors.
+func testV3CurlCORS(cx ctlCtx) {
+	rangeData, err := json.Marshal(&pb.RangeRequest{
+		Key: []byte("foo"),
+	})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	p := cx.apiPrefix
+	for _, tt := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{origin: "http://allowed.example.com", allowed: true},
+		{origin: "http://denied.example.com", allowed: false},
+	} {
+		req := e2e.CURLReq{Endpoint: path.Join(p, "/kv/range"), Value: string(rangeData), Header: "Origin: " + tt.origin}
+		args := e2e.CURLPrefixArgs(cx.epc.Procs[0].Config().Acurl, cx.cfg.ClientTLS, !cx.cfg.NoCN, "POST", req)
+		lines, err := e2e.RunUtilCompletion(append(args, "--include"), nil)
+		if err != nil {
+			cx.t.Fatalf("failed testV3CurlCORS range with curl using prefix (%s) (%v)", p, err)
+		}
+		out := strings.Join(lines, "\n")
+		if !strings.Contains(out, `"header"`) {
+			cx.t.Fatalf("expected a range response for origin %s, got %q", tt.origin, out)
+		}
+		if got := strings.Contains(out, "Access-Control-Allow-Origin: "+tt.origin); got != tt.allowed {
+			cx.t.Fatalf("expected Access-Control-Allow-Origin for origin %s to be present %v, got %v in %q", tt.origin, tt.allowed, got, out)
+		}
+		if !tt.allowed && strings.Contains(out, "Access-Control-Allow-Origin") {
+			cx.t.Fatalf("expected no Access-Control-Allow-Origin for origin %s, got %q", tt.origin, out)
+		}
+	}
+}
+
+// testV3CurlGRPCGatewayDisabled ensures the REST endpoints are not served
+// without the gRPC gateway, while the gRPC API keeps working.
+func testV3CurlGRPCGatewayDisabled(cx ctlCtx) {
+	putData, err := json.Marshal(&pb.PutRequest{
+		Key:   []byte("foo"),
+		Value: []byte("bar"),
+	})
+	if err != nil {
+		cx.t.Fatal(err)
+	}
+
+	p := cx.apiPrefix
+	if err = e2e.CURLPost(cx.epc, e2e.CURLReq{Endpoint: path.Join(p, "/kv/put"), Value: string(putData), Expected: "404 page not found"}); err != nil {
+		cx.t.Fatalf("failed testV3CurlGRPCGatewayDisabled put with curl using prefix (%s) (%v)", p, err)
+	}
+
+	if err = ctlV3Put(cx, "foo", "bar", ""); err != nil {
+		cx.t.Fatalf("failed testV3CurlGRPCGatewayDisabled put with etcdctl (%v)", err)
+	}
+	if err = ctlV3Get(cx, []string{"foo"}, kv{"foo", "bar"}); err != nil {
+		cx.t.Fatalf("failed testV3CurlGRPCGatewayDisabled get with etcdctl (%v)", err)
+	}
+}
+
 func testV3CurlWatch(cx ctlCtx) {
 	// store "bar" into "foo"
 	putreq, err := json.Marshal(&pb.PutRequest{Key: []byte("foo"), Value: []byte("bar")})
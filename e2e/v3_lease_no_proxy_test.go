@@ -23,9 +23,9 @@ import (
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/testutils"
 	clientv3 "go.etcd.io/etcd/client/v3"
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
-	"go.etcd.io/etcd/tests/v3/framework/testutils"
 )
 
 // TestLeaseRevoke_IgnoreOldLeader verifies that leases shouldn't be revoked
@@ -18,11 +18,13 @@
 package e2e
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
-	"go.etcd.io/etcd/tests/v3/framework/e2e"
+	"github.com/tc-sdn/etcd-tests/framework/e2e"
 )
 
 func TestServerJsonLogging(t *testing.T) {
@@ -70,6 +72,66 @@ func TestServerJsonLogging(t *testing.T) {
 	}
 }
 
+// warnUnaryRequestLatency mirrors the server side duration after which a
+// unary request is logged as expensive. etcd v3.5 has no
+// --experimental-warning-unary-request-duration flag to configure it.
+const warnUnaryRequestLatency = 300 * time.Millisecond
+
+// TestServerSlowUnaryRequestWarning ensures a unary request slowed down by a
+// delayed WAL sync is logged as expensive, along with the time it took.
+func TestServerSlowUnaryRequestWarning(t *testing.T) {
+	e2e.BeforeTest(t)
+
+	epc, err := e2e.NewEtcdProcessCluster(t, &e2e.EtcdProcessClusterConfig{
+		ClusterSize:   1,
+		GoFailEnabled: true,
+	})
+	if err != nil {
+		t.Fatalf("could not start etcd process cluster (%v)", err)
+	}
+	defer func() {
+		if errC := epc.Close(); errC != nil {
+			t.Fatalf("error closing etcd processes (%v)", errC)
+		}
+	}()
+
+	member := epc.Procs[0]
+	if !member.Failpoints().Available("raftBeforeSaveWaitWalSync") {
+		t.Skip("failpoint raftBeforeSaveWaitWalSync is not available in the etcd binary")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	delay := 2 * warnUnaryRequestLatency
+	if err = member.Failpoints().SetupHTTP(ctx, "raftBeforeSaveWaitWalSync", fmt.Sprintf(`sleep("%s")`, delay)); err != nil {
+		t.Fatal(err)
+	}
+	if err = member.Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).Put("foo", "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	line, err := member.Logs().Expect("request stats")
+	if err != nil {
+		t.Fatalf("expected the slow request to be logged (%v)", err)
+	}
+	var entry struct {
+		logEntry
+		TimeSpent string `json:"time spent"`
+	}
+	if err = json.Unmarshal([]byte(line), &entry); err != nil {
+		t.Fatalf("failed to parse log line as json, err: %q, line: %s", err, line)
+	}
+	if entry.Level != "warn" {
+		t.Errorf("expected the slow request to be logged as a warning, got level %q", entry.Level)
+	}
+	took, err := time.ParseDuration(entry.TimeSpent)
+	if err != nil {
+		t.Fatalf("failed to parse request duration %q (%v)", entry.TimeSpent, err)
+	}
+	if took < warnUnaryRequestLatency {
+		t.Errorf("expected the logged request duration to exceed %v, got %v", warnUnaryRequestLatency, took)
+	}
+}
+
 type logEntry struct {
 	Level     string `json:"level"`
 	Timestamp string `json:"ts"`
@@ -16,11 +16,15 @@ package e2e
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/exec"
 	"path"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
@@ -135,18 +139,30 @@ type EtcdProcessCluster struct {
 	lg    *zap.Logger
 	Cfg   *EtcdProcessClusterConfig
 	Procs []EtcdProcess
+
+	// tmpfsDataDirs are the member data dirs mounted by SimulateDiskFull,
+	// unmounted when the cluster is closed.
+	tmpfsDataDirs []string
 }
 
 type EtcdProcessClusterConfig struct {
 	ExecPath            string
 	DataDirPath         string
+	DataDirRoot         string // member data dirs are created under it, unless DataDirPath is set
 	KeepDataDir         bool
 	GoFailEnabled       bool
 	GoFailClientTimeout time.Duration
 	PeerProxy           bool
 	EnvVars             map[string]string
+	GoMemLimit          string // sets GOMEMLIMIT of every member
 
 	ClusterSize int
+	// ExecPathOverrides maps member indexes to the etcd binary they run
+	// instead of ExecPath, forming a mixed version cluster.
+	ExecPathOverrides map[int]string
+	// MemberNames overrides the default test-<index> name of the first
+	// len(MemberNames) members.
+	MemberNames []string
 
 	// BasePeerScheme specifies scheme of --listen-peer-urls and --initial-advertise-peer-urls
 	BasePeerScheme string
@@ -157,36 +173,77 @@ type EtcdProcessClusterConfig struct {
 	MetricsURLScheme string
 
 	SnapshotCount int // default is 10000
+	MaxSnapshots  uint
+	MaxWALs       uint
 
 	ClientTLS             ClientConnType
 	ClientCertAuthEnabled bool
+	TrustedCAFile         string // overrides CaPath as --trusted-ca-file
 	ClientHttpSeparate    bool
 	IsPeerTLS             bool
 	IsPeerAutoTLS         bool
 	IsClientAutoTLS       bool
 	IsClientCRL           bool
 	NoCN                  bool
+	// PeerClientCertAuth requires peers to present a certificate whose SAN
+	// matches their address, unless PeerSkipClientSANVerify is set.
+	PeerClientCertAuth      bool
+	PeerSkipClientSANVerify bool
 
 	CipherSuites []string
 
 	ForceNewCluster     bool
 	InitialToken        string
 	QuotaBackendBytes   int64
+	MaxRequestBytes     uint
 	NoStrictReconfig    bool
+	NoGRPCGateway       bool
 	EnableV2            bool
 	InitialCorruptCheck bool
 	AuthTokenOpts       string
 	V2deprecation       string
+	CorsOrigins         string // comma separated origins allowed by --cors
+
+	// DiscoverySRV bootstraps members from the SRV records of the given
+	// domain instead of a static --initial-cluster.
+	DiscoverySRV string
+	// DiscoverySRVName scopes DiscoverySRV to the _etcd-server-<name> records,
+	// so several clusters can share one domain.
+	DiscoverySRVName string
+
+	// JWTPubKeyFile and JWTPrivKeyFile configure a JWT auth token provider
+	// signing with the given key pair. They are ignored when AuthTokenOpts is set.
+	JWTPubKeyFile  string
+	JWTPrivKeyFile string
+	JWTSignMethod  string // default is RS256
+	JWTTokenTTL    time.Duration
 
 	RollingStart bool
 	LogLevel     string
 
+	// StartupTimeout bounds how long starting the cluster waits for every
+	// member to be ready. Zero waits indefinitely.
+	StartupTimeout time.Duration
+
+	// LogCaptureLines makes every member keep that many of its latest log
+	// lines, see MemberLogs.
+	LogCaptureLines int
+
 	MaxConcurrentStreams       uint32 // default is math.MaxUint32
 	CorruptCheckTime           time.Duration
 	CompactHashCheckEnabled    bool
 	CompactHashCheckTime       time.Duration
 	WatchProcessNotifyInterval time.Duration
 	CompactionBatchLimit       int
+	// StopGRPCServiceOnDefrag reports the gRPC health service of a member as
+	// NOT_SERVING while it defragments, so health checking clients fail over.
+	StopGRPCServiceOnDefrag bool
+
+	EnableDistributedTracing bool
+	// DistributedTracingAddress is the address of the OTLP collector spans are exported to.
+	DistributedTracingAddress string
+	// DistributedTracingSamplingRate is the number of spans sampled per million.
+	DistributedTracingSamplingRate int
 }
 
 // NewEtcdProcessCluster launches a new cluster from etcd processes, returning
@@ -254,6 +311,38 @@ func (cfg *EtcdProcessClusterConfig) PeerScheme() string {
 }
 
 func (cfg *EtcdProcessClusterConfig) EtcdServerProcessConfigs(tb testing.TB) []*EtcdServerProcessConfig {
+	etcdCfgs := make([]*EtcdServerProcessConfig, cfg.ClusterSize)
+	initialCluster := make([]string, cfg.ClusterSize)
+	for i := 0; i < cfg.ClusterSize; i++ {
+		etcdCfgs[i] = cfg.EtcdServerProcessConfig(tb, i)
+		initialCluster[i] = fmt.Sprintf("%s=%s", etcdCfgs[i].Name, etcdCfgs[i].Purl.String())
+	}
+
+	initialClusterArgs := []string{"--initial-cluster", strings.Join(initialCluster, ",")}
+	if cfg.DiscoverySRV != "" {
+		initialClusterArgs = []string{"--discovery-srv", cfg.DiscoverySRV}
+		if cfg.DiscoverySRVName != "" {
+			initialClusterArgs = append(initialClusterArgs, "--discovery-srv-name", cfg.DiscoverySRVName)
+		}
+	}
+	for i := range etcdCfgs {
+		etcdCfgs[i].InitialCluster = strings.Join(initialCluster, ",")
+		etcdCfgs[i].Args = append(etcdCfgs[i].Args, initialClusterArgs...)
+	}
+
+	return etcdCfgs
+}
+
+// SetInitialCluster replaces the --initial-cluster of the member, so members
+// of one cluster can be given inconsistent bootstrap configurations.
+func (cfg *EtcdServerProcessConfig) SetInitialCluster(initialCluster string) {
+	cfg.InitialCluster = initialCluster
+	cfg.Args = PatchArgs(cfg.Args, "initial-cluster", initialCluster)
+}
+
+// EtcdServerProcessConfig returns the configuration of the i-th member.
+// The returned config does not include --initial-cluster.
+func (cfg *EtcdProcessClusterConfig) EtcdServerProcessConfig(tb testing.TB, i int) *EtcdServerProcessConfig {
 	lg := zaptest.NewLogger(tb)
 
 	if cfg.BasePort == 0 {
@@ -266,160 +355,211 @@ func (cfg *EtcdProcessClusterConfig) EtcdServerProcessConfigs(tb testing.TB) []*
 		cfg.SnapshotCount = etcdserver.DefaultSnapshotCount
 	}
 
-	etcdCfgs := make([]*EtcdServerProcessConfig, cfg.ClusterSize)
-	initialCluster := make([]string, cfg.ClusterSize)
-	for i := 0; i < cfg.ClusterSize; i++ {
-		var curls []string
-		var curl string
-		port := cfg.BasePort + 5*i
-		clientPort := port
-		peerPort := port + 1
-		peer2Port := port + 3
-		clientHttpPort := port + 4
-
-		if cfg.ClientTLS == ClientTLSAndNonTLS {
-			curl = clientURL(cfg.ClientScheme(), clientPort, ClientNonTLS)
-			curls = []string{curl, clientURL(cfg.ClientScheme(), clientPort, ClientTLS)}
-		} else {
-			curl = clientURL(cfg.ClientScheme(), clientPort, cfg.ClientTLS)
-			curls = []string{curl}
-		}
+	var curls []string
+	var curl string
+	port := cfg.BasePort + 5*i
+	clientPort := port
+	peerPort := port + 1
+	peer2Port := port + 3
+	clientHttpPort := port + 4
+
+	if cfg.ClientTLS == ClientTLSAndNonTLS {
+		curl = clientURL(cfg.ClientScheme(), clientPort, ClientNonTLS)
+		curls = []string{curl, clientURL(cfg.ClientScheme(), clientPort, ClientTLS)}
+	} else {
+		curl = clientURL(cfg.ClientScheme(), clientPort, cfg.ClientTLS)
+		curls = []string{curl}
+	}
 
-		purl := url.URL{Scheme: cfg.PeerScheme(), Host: fmt.Sprintf("localhost:%d", peerPort)}
-		peerAdvertiseUrl := url.URL{Scheme: cfg.PeerScheme(), Host: fmt.Sprintf("localhost:%d", peerPort)}
-		var proxyCfg *proxy.ServerConfig
-		if cfg.PeerProxy {
-			if !cfg.IsPeerTLS {
-				panic("Can't use peer proxy without peer TLS as it can result in malformed packets")
-			}
-			peerAdvertiseUrl.Host = fmt.Sprintf("localhost:%d", peer2Port)
-			proxyCfg = &proxy.ServerConfig{
-				Logger: zap.NewNop(),
-				To:     purl,
-				From:   peerAdvertiseUrl,
-			}
+	purl := url.URL{Scheme: cfg.PeerScheme(), Host: fmt.Sprintf("localhost:%d", peerPort)}
+	peerAdvertiseUrl := url.URL{Scheme: cfg.PeerScheme(), Host: fmt.Sprintf("localhost:%d", peerPort)}
+	var proxyCfg *proxy.ServerConfig
+	if cfg.PeerProxy {
+		if !cfg.IsPeerTLS {
+			panic("Can't use peer proxy without peer TLS as it can result in malformed packets")
 		}
+		peerAdvertiseUrl.Host = fmt.Sprintf("localhost:%d", peer2Port)
+		proxyCfg = &proxy.ServerConfig{
+			Logger: zap.NewNop(),
+			To:     purl,
+			From:   peerAdvertiseUrl,
+		}
+	}
 
-		name := fmt.Sprintf("test-%d", i)
-		dataDirPath := cfg.DataDirPath
-		if cfg.DataDirPath == "" {
+	name := fmt.Sprintf("test-%d", i)
+	if i < len(cfg.MemberNames) {
+		name = cfg.MemberNames[i]
+	}
+	dataDirPath := cfg.DataDirPath
+	if cfg.DataDirPath == "" {
+		if cfg.DataDirRoot != "" {
+			dir, err := os.MkdirTemp(cfg.DataDirRoot, name+".etcd")
+			if err != nil {
+				tb.Fatal(err)
+			}
+			dataDirPath = dir
+		} else {
 			dataDirPath = tb.TempDir()
 		}
-		initialCluster[i] = fmt.Sprintf("%s=%s", name, peerAdvertiseUrl.String())
+	}
 
-		args := []string{
-			"--name", name,
-			"--listen-client-urls", strings.Join(curls, ","),
-			"--advertise-client-urls", strings.Join(curls, ","),
-			"--listen-peer-urls", purl.String(),
-			"--initial-advertise-peer-urls", peerAdvertiseUrl.String(),
-			"--initial-cluster-token", cfg.InitialToken,
-			"--data-dir", dataDirPath,
-			"--snapshot-count", fmt.Sprintf("%d", cfg.SnapshotCount),
-		}
-		var clientHttpUrl string
-		if cfg.ClientHttpSeparate {
-			clientHttpUrl = clientURL(cfg.ClientScheme(), clientHttpPort, cfg.ClientTLS)
-			args = append(args, "--listen-client-http-urls", clientHttpUrl)
-		}
-		args = AddV2Args(args)
-		if cfg.ForceNewCluster {
-			args = append(args, "--force-new-cluster")
-		}
-		if cfg.QuotaBackendBytes > 0 {
-			args = append(args,
-				"--quota-backend-bytes", fmt.Sprintf("%d", cfg.QuotaBackendBytes),
-			)
-		}
-		if cfg.NoStrictReconfig {
-			args = append(args, "--strict-reconfig-check=false")
-		}
-		if cfg.EnableV2 {
-			args = append(args, "--enable-v2")
-		}
-		if cfg.InitialCorruptCheck {
-			args = append(args, "--experimental-initial-corrupt-check")
-		}
-		var murl string
-		if cfg.MetricsURLScheme != "" {
-			murl = (&url.URL{
-				Scheme: cfg.MetricsURLScheme,
-				Host:   fmt.Sprintf("localhost:%d", port+2),
-			}).String()
-			args = append(args, "--listen-metrics-urls", murl)
-		}
+	args := []string{
+		"--name", name,
+		"--listen-client-urls", strings.Join(curls, ","),
+		"--advertise-client-urls", strings.Join(curls, ","),
+		"--listen-peer-urls", purl.String(),
+		"--initial-advertise-peer-urls", peerAdvertiseUrl.String(),
+		"--initial-cluster-token", cfg.InitialToken,
+		"--data-dir", dataDirPath,
+		"--snapshot-count", fmt.Sprintf("%d", cfg.SnapshotCount),
+	}
+	var clientHttpUrl string
+	if cfg.ClientHttpSeparate {
+		clientHttpUrl = clientURL(cfg.ClientScheme(), clientHttpPort, cfg.ClientTLS)
+		args = append(args, "--listen-client-http-urls", clientHttpUrl)
+	}
+	args = AddV2Args(args)
+	if cfg.MaxSnapshots != 0 {
+		args = append(args, "--max-snapshots", fmt.Sprintf("%d", cfg.MaxSnapshots))
+	}
+	if cfg.MaxWALs != 0 {
+		args = append(args, "--max-wals", fmt.Sprintf("%d", cfg.MaxWALs))
+	}
+	if cfg.ForceNewCluster {
+		args = append(args, "--force-new-cluster")
+	}
+	if cfg.QuotaBackendBytes > 0 {
+		args = append(args,
+			"--quota-backend-bytes", fmt.Sprintf("%d", cfg.QuotaBackendBytes),
+		)
+	}
+	if cfg.MaxRequestBytes > 0 {
+		args = append(args, "--max-request-bytes", fmt.Sprintf("%d", cfg.MaxRequestBytes))
+	}
+	if cfg.NoStrictReconfig {
+		args = append(args, "--strict-reconfig-check=false")
+	}
+	if cfg.NoGRPCGateway {
+		args = append(args, "--enable-grpc-gateway=false")
+	}
+	if cfg.EnableV2 {
+		args = append(args, "--enable-v2")
+	}
+	if cfg.InitialCorruptCheck {
+		args = append(args, "--experimental-initial-corrupt-check")
+	}
+	var murl string
+	if cfg.MetricsURLScheme != "" {
+		murl = (&url.URL{
+			Scheme: cfg.MetricsURLScheme,
+			Host:   fmt.Sprintf("localhost:%d", port+2),
+		}).String()
+		args = append(args, "--listen-metrics-urls", murl)
+	}
 
-		args = append(args, cfg.TlsArgs()...)
+	args = append(args, cfg.TlsArgs()...)
 
-		if cfg.AuthTokenOpts != "" {
-			args = append(args, "--auth-token", cfg.AuthTokenOpts)
-		}
+	if authTokenOpts := cfg.authTokenOpts(); authTokenOpts != "" {
+		args = append(args, "--auth-token", authTokenOpts)
+	}
 
-		if cfg.V2deprecation != "" {
-			args = append(args, "--v2-deprecation", cfg.V2deprecation)
-		}
+	if cfg.V2deprecation != "" {
+		args = append(args, "--v2-deprecation", cfg.V2deprecation)
+	}
 
-		if cfg.LogLevel != "" {
-			args = append(args, "--log-level", cfg.LogLevel)
-		}
+	if cfg.CorsOrigins != "" {
+		args = append(args, "--cors", cfg.CorsOrigins)
+	}
 
-		if cfg.MaxConcurrentStreams != 0 {
-			args = append(args, "--max-concurrent-streams", fmt.Sprintf("%d", cfg.MaxConcurrentStreams))
-		}
+	if cfg.LogLevel != "" {
+		args = append(args, "--log-level", cfg.LogLevel)
+	}
 
-		if cfg.CorruptCheckTime != 0 {
-			args = append(args, "--experimental-corrupt-check-time", fmt.Sprintf("%s", cfg.CorruptCheckTime))
-		}
-		if cfg.CompactHashCheckEnabled {
-			args = append(args, "--experimental-compact-hash-check-enabled")
-		}
-		if cfg.CompactHashCheckTime != 0 {
-			args = append(args, "--experimental-compact-hash-check-time", cfg.CompactHashCheckTime.String())
-		}
-		if cfg.WatchProcessNotifyInterval != 0 {
-			args = append(args, "--experimental-watch-progress-notify-interval", cfg.WatchProcessNotifyInterval.String())
-		}
-		if cfg.CompactionBatchLimit != 0 {
-			args = append(args, "--experimental-compaction-batch-limit", fmt.Sprintf("%d", cfg.CompactionBatchLimit))
-		}
+	if cfg.MaxConcurrentStreams != 0 {
+		args = append(args, "--max-concurrent-streams", fmt.Sprintf("%d", cfg.MaxConcurrentStreams))
+	}
 
-		envVars := map[string]string{}
-		for key, value := range cfg.EnvVars {
-			envVars[key] = value
-		}
-		var gofailPort int
-		if cfg.GoFailEnabled {
-			gofailPort = (i+1)*10000 + 2381
-			envVars["GOFAIL_HTTP"] = fmt.Sprintf("127.0.0.1:%d", gofailPort)
+	if cfg.CorruptCheckTime != 0 {
+		args = append(args, "--experimental-corrupt-check-time", fmt.Sprintf("%s", cfg.CorruptCheckTime))
+	}
+	if cfg.CompactHashCheckEnabled {
+		args = append(args, "--experimental-compact-hash-check-enabled")
+	}
+	if cfg.CompactHashCheckTime != 0 {
+		args = append(args, "--experimental-compact-hash-check-time", cfg.CompactHashCheckTime.String())
+	}
+	if cfg.WatchProcessNotifyInterval != 0 {
+		args = append(args, "--experimental-watch-progress-notify-interval", cfg.WatchProcessNotifyInterval.String())
+	}
+	if cfg.CompactionBatchLimit != 0 {
+		args = append(args, "--experimental-compaction-batch-limit", fmt.Sprintf("%d", cfg.CompactionBatchLimit))
+	}
+	if cfg.StopGRPCServiceOnDefrag {
+		args = append(args, "--experimental-stop-grpc-service-on-defrag")
+	}
+	if cfg.EnableDistributedTracing {
+		args = append(args,
+			"--experimental-enable-distributed-tracing",
+			"--experimental-distributed-tracing-instance-id", name,
+			"--experimental-distributed-tracing-sampling-rate", fmt.Sprintf("%d", cfg.DistributedTracingSamplingRate),
+		)
+		if cfg.DistributedTracingAddress != "" {
+			args = append(args, "--experimental-distributed-tracing-address", cfg.DistributedTracingAddress)
 		}
+	}
 
-		etcdCfgs[i] = &EtcdServerProcessConfig{
-			lg:                  lg,
-			ExecPath:            cfg.ExecPath,
-			Args:                args,
-			EnvVars:             envVars,
-			TlsArgs:             cfg.TlsArgs(),
-			DataDirPath:         dataDirPath,
-			KeepDataDir:         cfg.KeepDataDir,
-			Name:                name,
-			Purl:                peerAdvertiseUrl,
-			Acurl:               curl,
-			Murl:                murl,
-			InitialToken:        cfg.InitialToken,
-			ClientHttpUrl:       clientHttpUrl,
-			GoFailPort:          gofailPort,
-			GoFailClientTimeout: cfg.GoFailClientTimeout,
-			Proxy:               proxyCfg,
-		}
+	envVars := map[string]string{}
+	for key, value := range cfg.EnvVars {
+		envVars[key] = value
+	}
+	if cfg.GoMemLimit != "" {
+		envVars["GOMEMLIMIT"] = cfg.GoMemLimit
+	}
+	var gofailPort int
+	if cfg.GoFailEnabled {
+		gofailPort = (i+1)*10000 + 2381
+		envVars["GOFAIL_HTTP"] = fmt.Sprintf("127.0.0.1:%d", gofailPort)
 	}
 
-	initialClusterArgs := []string{"--initial-cluster", strings.Join(initialCluster, ",")}
-	for i := range etcdCfgs {
-		etcdCfgs[i].InitialCluster = strings.Join(initialCluster, ",")
-		etcdCfgs[i].Args = append(etcdCfgs[i].Args, initialClusterArgs...)
+	execPath := cfg.ExecPath
+	if p, ok := cfg.ExecPathOverrides[i]; ok {
+		execPath = p
 	}
 
-	return etcdCfgs
+	return &EtcdServerProcessConfig{
+		lg:                  lg,
+		ExecPath:            execPath,
+		Args:                args,
+		EnvVars:             envVars,
+		TlsArgs:             cfg.TlsArgs(),
+		DataDirPath:         dataDirPath,
+		KeepDataDir:         cfg.KeepDataDir,
+		Name:                name,
+		Purl:                peerAdvertiseUrl,
+		Acurl:               curl,
+		Murl:                murl,
+		InitialToken:        cfg.InitialToken,
+		ClientHttpUrl:       clientHttpUrl,
+		GoFailPort:          gofailPort,
+		GoFailClientTimeout: cfg.GoFailClientTimeout,
+		Proxy:               proxyCfg,
+		LogCaptureLines:     cfg.LogCaptureLines,
+	}
+}
+
+func (cfg *EtcdProcessClusterConfig) authTokenOpts() string {
+	if cfg.AuthTokenOpts != "" || cfg.JWTPrivKeyFile == "" {
+		return cfg.AuthTokenOpts
+	}
+	signMethod := cfg.JWTSignMethod
+	if signMethod == "" {
+		signMethod = "RS256"
+	}
+	opts := fmt.Sprintf("jwt,pub-key=%s,priv-key=%s,sign-method=%s", cfg.JWTPubKeyFile, cfg.JWTPrivKeyFile, signMethod)
+	if cfg.JWTTokenTTL != 0 {
+		opts += ",ttl=" + cfg.JWTTokenTTL.String()
+	}
+	return opts
 }
 
 func clientURL(scheme string, port int, connType ClientConnType) string {
@@ -439,10 +579,14 @@ func (cfg *EtcdProcessClusterConfig) TlsArgs() (args []string) {
 		if cfg.IsClientAutoTLS {
 			args = append(args, "--auto-tls")
 		} else {
+			trustedCAFile := CaPath
+			if cfg.TrustedCAFile != "" {
+				trustedCAFile = cfg.TrustedCAFile
+			}
 			tlsClientArgs := []string{
 				"--cert-file", CertPath,
 				"--key-file", PrivateKeyPath,
-				"--trusted-ca-file", CaPath,
+				"--trusted-ca-file", trustedCAFile,
 			}
 			args = append(args, tlsClientArgs...)
 
@@ -463,6 +607,12 @@ func (cfg *EtcdProcessClusterConfig) TlsArgs() (args []string) {
 			}
 			args = append(args, tlsPeerArgs...)
 		}
+		if cfg.PeerClientCertAuth {
+			args = append(args, "--peer-client-cert-auth")
+		}
+		if cfg.PeerSkipClientSANVerify {
+			args = append(args, "--peer-skip-client-san-verification")
+		}
 	}
 
 	if cfg.IsClientCRL {
@@ -511,31 +661,59 @@ func (epc *EtcdProcessCluster) Restart() error {
 	return epc.start(func(ep EtcdProcess) error { return ep.Restart() })
 }
 
+// RollingRestart restarts the members one at a time, waiting for each to be
+// ready before restarting the next, so the cluster keeps its quorum.
+func (epc *EtcdProcessCluster) RollingRestart() error {
+	for _, p := range epc.Procs {
+		if err := p.Restart(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (epc *EtcdProcessCluster) start(f func(ep EtcdProcess) error) error {
+	timeoutC := epc.startupTimeoutC()
 	readyC := make(chan error, len(epc.Procs))
 	for i := range epc.Procs {
 		go func(n int) { readyC <- f(epc.Procs[n]) }(i)
 	}
-	for range epc.Procs {
-		if err := <-readyC; err != nil {
-			epc.Close()
-			return err
-		}
-	}
-	return nil
+	return epc.waitStarted(readyC, timeoutC)
 }
 
 func (epc *EtcdProcessCluster) rollingStart(f func(ep EtcdProcess) error) error {
+	timeoutC := epc.startupTimeoutC()
 	readyC := make(chan error, len(epc.Procs))
 	for i := range epc.Procs {
 		go func(n int) { readyC <- f(epc.Procs[n]) }(i)
 		// make sure the servers do not start at the same time
 		time.Sleep(time.Second)
 	}
+	return epc.waitStarted(readyC, timeoutC)
+}
+
+// startupTimeoutC fires once the configured StartupTimeout elapses, or never
+// if it is unset.
+func (epc *EtcdProcessCluster) startupTimeoutC() <-chan time.Time {
+	if epc.Cfg.StartupTimeout <= 0 {
+		return nil
+	}
+	return time.After(epc.Cfg.StartupTimeout)
+}
+
+// waitStarted waits for every member to report on readyC. The cluster is
+// closed if any member fails or timeoutC fires first.
+func (epc *EtcdProcessCluster) waitStarted(readyC <-chan error, timeoutC <-chan time.Time) error {
 	for range epc.Procs {
-		if err := <-readyC; err != nil {
+		select {
+		case err := <-readyC:
+			if err != nil {
+				epc.Close()
+				return err
+			}
+		case <-timeoutC:
 			epc.Close()
-			return err
+			return fmt.Errorf("members not ready within %v", epc.Cfg.StartupTimeout)
 		}
 	}
 	return nil
@@ -560,6 +738,11 @@ func (epc *EtcdProcessCluster) Stop() (err error) {
 func (epc *EtcdProcessCluster) Close() error {
 	epc.lg.Info("closing test cluster...")
 	err := epc.Stop()
+	for _, dir := range epc.tmpfsDataDirs {
+		if uerr := unmountTmpfs(dir); uerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to unmount %q: %w", dir, uerr))
+		}
+	}
 	for _, p := range epc.Procs {
 		// p is nil when NewEtcdProcess fails in the middle
 		// Close still gets called to clean up test data
@@ -574,6 +757,12 @@ func (epc *EtcdProcessCluster) Close() error {
 	return err
 }
 
+// MemberLogs returns the latest log lines of the member at idx, which
+// are only captured when LogCaptureLines is set.
+func (epc *EtcdProcessCluster) MemberLogs(idx int) []string {
+	return epc.Procs[idx].CapturedLogs()
+}
+
 func (epc *EtcdProcessCluster) WithStopSignal(sig os.Signal) (ret os.Signal) {
 	for _, p := range epc.Procs {
 		ret = p.WithStopSignal(sig)
@@ -648,3 +837,266 @@ func (epc *EtcdProcessCluster) WaitMembersForLeader(ctx context.Context, t testi
 	t.Fatal("impossible path of execution")
 	return -1
 }
+
+// Leader returns the running member whose endpoint status reports it is the
+// leader. It fails unless exactly one member claims leadership.
+func (epc *EtcdProcessCluster) Leader(ctx context.Context) (EtcdProcess, error) {
+	var leaders []EtcdProcess
+	for _, proc := range epc.Procs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !proc.IsRunning() {
+			continue
+		}
+		resp, err := proc.Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, epc.Cfg.EnableV2).Status()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of %s: %w", proc.Config().Name, err)
+		}
+		if len(resp) != 1 {
+			return nil, fmt.Errorf("expected a single endpoint status from %s, got %d", proc.Config().Name, len(resp))
+		}
+		if resp[0].Leader == resp[0].Header.MemberId {
+			leaders = append(leaders, proc)
+		}
+	}
+	switch len(leaders) {
+	case 0:
+		return nil, errors.New("no member claims leadership")
+	case 1:
+		return leaders[0], nil
+	}
+	names := make([]string, len(leaders))
+	for i, l := range leaders {
+		names[i] = l.Config().Name
+	}
+	return nil, fmt.Errorf("several members claim leadership: %v", names)
+}
+
+// NewMemberConfig returns the configuration of a new member that joins the
+// running cluster. Its --initial-cluster lists the current members and itself.
+func (epc *EtcdProcessCluster) NewMemberConfig(tb testing.TB) *EtcdServerProcessConfig {
+	cfg := epc.Cfg.EtcdServerProcessConfig(tb, len(epc.Procs))
+
+	initialCluster := make([]string, 0, len(epc.Procs)+1)
+	for _, p := range epc.Procs {
+		initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", p.Config().Name, p.Config().Purl.String()))
+	}
+	initialCluster = append(initialCluster, fmt.Sprintf("%s=%s", cfg.Name, cfg.Purl.String()))
+
+	cfg.InitialCluster = strings.Join(initialCluster, ",")
+	cfg.Args = append(cfg.Args,
+		"--initial-cluster", cfg.InitialCluster,
+		"--initial-cluster-state", "existing",
+	)
+	return cfg
+}
+
+// StartNewProc starts a member process with the given configuration and
+// appends it to the cluster. The member must be added to the cluster
+// membership (e.g. via "member add") before it is started.
+func (epc *EtcdProcessCluster) StartNewProc(cfg *EtcdServerProcessConfig) (EtcdProcess, error) {
+	proc, err := NewEtcdProcess(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// track the process before starting it, so Close cleans it up on failure
+	epc.Procs = append(epc.Procs, proc)
+	epc.Cfg.ClusterSize = len(epc.Procs)
+	return proc, proc.Start()
+}
+
+// AddLearner adds a learner member to the cluster membership, then starts
+// its process and appends it to the cluster.
+func (epc *EtcdProcessCluster) AddLearner(ctx context.Context, tb testing.TB) (EtcdProcess, error) {
+	eps, err := epc.votingEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cfg := epc.NewMemberConfig(tb)
+	ctl := NewEtcdctl(eps, epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false)
+	if _, err = ctl.MemberAddAsLearner(cfg.Name, []string{cfg.Purl.String()}); err != nil {
+		return nil, fmt.Errorf("failed to add learner %s: %w", cfg.Name, err)
+	}
+	return epc.StartNewProc(cfg)
+}
+
+// PromoteLearner promotes the learner with the given ID to a voting member,
+// retrying until the learner has caught up with the leader or ctx is done.
+func (epc *EtcdProcessCluster) PromoteLearner(ctx context.Context, id uint64) error {
+	eps, err := epc.votingEndpoints(ctx)
+	if err != nil {
+		return err
+	}
+	ctl := NewEtcdctl(eps, epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false)
+	for {
+		_, err = ctl.MemberPromote(id)
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to promote learner %x: %w", id, err)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// votingEndpoints returns the client endpoints of the running members that
+// are not learners, as learners reject membership requests.
+func (epc *EtcdProcessCluster) votingEndpoints(ctx context.Context) ([]string, error) {
+	var eps []string
+	for _, proc := range epc.Procs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !proc.IsRunning() {
+			continue
+		}
+		resp, err := proc.Etcdctl(epc.Cfg.ClientTLS, epc.Cfg.IsClientAutoTLS, false).Status()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get status of %s: %w", proc.Config().Name, err)
+		}
+		if len(resp) == 1 && !resp[0].IsLearner {
+			eps = append(eps, proc.EndpointsV3()...)
+		}
+	}
+	if len(eps) == 0 {
+		return nil, errors.New("no running voting member")
+	}
+	return eps, nil
+}
+
+// RemoveProc closes the given member process and drops it from the cluster.
+// The member should be removed from the cluster membership beforehand.
+func (epc *EtcdProcessCluster) RemoveProc(proc EtcdProcess) error {
+	for i, p := range epc.Procs {
+		if p != proc {
+			continue
+		}
+		epc.Procs = append(epc.Procs[:i], epc.Procs[i+1:]...)
+		epc.Cfg.ClusterSize = len(epc.Procs)
+		return proc.Close()
+	}
+	return fmt.Errorf("process %q is not part of the cluster", proc.Config().Name)
+}
+
+// MakeDataDirReadOnly removes the write permission from the data dir of the
+// member at idx and everything in it. The member should be stopped first.
+func (epc *EtcdProcessCluster) MakeDataDirReadOnly(idx int) error {
+	return chmodDataDir(epc.Procs[idx].Config().DataDirPath, 0500, 0400)
+}
+
+// MakeDataDirWritable restores the write permission on the data dir of the
+// member at idx, undoing MakeDataDirReadOnly.
+func (epc *EtcdProcessCluster) MakeDataDirWritable(idx int) error {
+	return chmodDataDir(epc.Procs[idx].Config().DataDirPath, 0700, 0600)
+}
+
+func chmodDataDir(dir string, dirMode, fileMode os.FileMode) error {
+	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(p, dirMode)
+		}
+		return os.Chmod(p, fileMode)
+	})
+}
+
+// diskFullHeadroom is the space left on the tmpfs data dir of a member by
+// SimulateDiskFull for the member to restart, which covers the preallocation
+// of its next WAL segment.
+const diskFullHeadroom = 96 * 1024 * 1024
+
+// diskFillerFile is the file, in the data dir of a member, taking up the free
+// space left by SimulateDiskFull.
+const diskFillerFile = "disk-filler"
+
+// SimulateDiskFull moves the data dir of the member at idx onto a tmpfs just
+// large enough for the member to restart, then fills the remaining space so
+// that its next writes fail with ENOSPC. Mounting the tmpfs requires
+// CAP_SYS_ADMIN, without which the returned error wraps syscall.EPERM and the
+// member is left stopped. The data on the tmpfs is lost when the cluster is
+// closed.
+func (epc *EtcdProcessCluster) SimulateDiskFull(idx int) error {
+	proc := epc.Procs[idx]
+	dataDir := proc.Config().DataDirPath
+	if err := proc.Stop(); err != nil {
+		return err
+	}
+
+	var size int64
+	err := filepath.Walk(dataDir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	orig := dataDir + ".orig"
+	if err = os.Rename(dataDir, orig); err != nil {
+		return err
+	}
+	if err = os.Mkdir(dataDir, 0700); err != nil {
+		return err
+	}
+	opts := fmt.Sprintf("size=%d,mode=0700", size+diskFullHeadroom)
+	if merr := mountTmpfs(dataDir, opts); merr != nil {
+		os.Remove(dataDir)
+		os.Rename(orig, dataDir)
+		return fmt.Errorf("failed to mount tmpfs on %q: %w", dataDir, merr)
+	}
+	epc.tmpfsDataDirs = append(epc.tmpfsDataDirs, dataDir)
+
+	if out, cerr := exec.Command("cp", "-a", orig+"/.", dataDir).CombinedOutput(); cerr != nil {
+		return fmt.Errorf("failed to copy %q to tmpfs (%v): %s", orig, cerr, out)
+	}
+	if err = os.RemoveAll(orig); err != nil {
+		return err
+	}
+	if err = proc.Start(); err != nil {
+		return err
+	}
+	return fillDisk(filepath.Join(dataDir, diskFillerFile))
+}
+
+// FreeDiskSpace undoes SimulateDiskFull on the member at idx, removing the
+// filler and growing its tmpfs so the member can write again.
+func (epc *EtcdProcessCluster) FreeDiskSpace(idx int) error {
+	dataDir := epc.Procs[idx].Config().DataDirPath
+	if err := os.Remove(filepath.Join(dataDir, diskFillerFile)); err != nil {
+		return err
+	}
+	if err := remountTmpfs(dataDir, "size=1g"); err != nil {
+		return fmt.Errorf("failed to grow tmpfs on %q: %w", dataDir, err)
+	}
+	return nil
+}
+
+// fillDisk writes to fpath until the file system it is on runs out of space.
+func fillDisk(fpath string) error {
+	f, err := os.OpenFile(fpath, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// shrink the writes once the larger ones no longer fit
+	for _, n := range []int{1024 * 1024, 4096} {
+		buf := make([]byte, n)
+		for err == nil {
+			_, err = f.Write(buf)
+		}
+		if !errors.Is(err, syscall.ENOSPC) {
+			return err
+		}
+		err = nil
+	}
+	return nil
+}
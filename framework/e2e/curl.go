@@ -18,6 +18,9 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 )
 
 type CURLReq struct {
@@ -121,3 +124,27 @@ func CURLPut(clus *EtcdProcessCluster, req CURLReq) error {
 func CURLGet(clus *EtcdProcessCluster, req CURLReq) error {
 	return SpawnWithExpect(CURLPrefixArgsCluster(clus, "GET", req), req.Expected)
 }
+
+// V3HTTPStatus returns the status of the member at idx, requested through the
+// gRPC gateway at /v3/maintenance/status rather than through gRPC.
+func (epc *EtcdProcessCluster) V3HTTPStatus(idx int) (*etcdserverpb.StatusResponse, error) {
+	req := CURLReq{Endpoint: "/v3/maintenance/status", Value: "{}"}
+	args := CURLPrefixArgs(epc.Procs[idx].Config().Acurl, epc.Cfg.ClientTLS, !epc.Cfg.NoCN, "POST", req)
+	lines, err := SpawnWithExpectLines(args, nil, `"header"`)
+	if err != nil {
+		return nil, err
+	}
+	var resp etcdserverpb.StatusResponse
+	if err = (&runtime.JSONPb{}).Unmarshal([]byte(strings.TrimSpace(lines[0])), &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// V3HTTPDefragment defragments the backend of the member at idx through the
+// gRPC gateway at /v3/maintenance/defragment rather than through gRPC.
+func (epc *EtcdProcessCluster) V3HTTPDefragment(idx int) error {
+	req := CURLReq{Endpoint: "/v3/maintenance/defragment", Value: "{}", Expected: `"header"`}
+	args := CURLPrefixArgs(epc.Procs[idx].Config().Acurl, epc.Cfg.ClientTLS, !epc.Cfg.NoCN, "POST", req)
+	return SpawnWithExpect(args, req.Expected)
+}
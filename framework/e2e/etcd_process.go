@@ -15,6 +15,7 @@
 package e2e
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"errors"
@@ -23,7 +24,10 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"go.etcd.io/etcd/client/pkg/v3/fileutil"
@@ -54,6 +58,7 @@ type EtcdProcess interface {
 	WithStopSignal(sig os.Signal) os.Signal
 	Config() *EtcdServerProcessConfig
 	Logs() LogsExpect
+	CapturedLogs() []string
 
 	PeerProxy() proxy.Server
 	Failpoints() *BinaryFailpoints
@@ -74,6 +79,9 @@ type EtcdServerProcess struct {
 	proxy      proxy.Server
 	failpoints *BinaryFailpoints
 	donec      chan struct{} // closed when Interact() terminates
+	logs       *logRing      // latest log lines, nil unless LogCaptureLines is set
+	logDir     string        // holds the FIFO the current run logs to
+	logsDone   chan struct{} // closed once the log output of the current run is read
 }
 
 type EtcdServerProcessConfig struct {
@@ -99,6 +107,13 @@ type EtcdServerProcessConfig struct {
 	GoFailPort          int
 	GoFailClientTimeout time.Duration
 	Proxy               *proxy.ServerConfig
+
+	// LogCaptureLines is how many of the latest log lines, across restarts,
+	// the member keeps for CapturedLogs. When set, the member logs to a FIFO
+	// read line by line into a ring of that size, so the memory held stays
+	// bounded while the member runs, and Logs only sees the kept lines.
+	// Zero disables the capture.
+	LogCaptureLines int
 }
 
 func NewEtcdServerProcess(cfg *EtcdServerProcessConfig) (*EtcdServerProcess, error) {
@@ -117,6 +132,9 @@ func NewEtcdServerProcess(cfg *EtcdServerProcessConfig) (*EtcdServerProcess, err
 			clientTimeout: cfg.GoFailClientTimeout,
 		}
 	}
+	if cfg.LogCaptureLines > 0 {
+		ep.logs = newLogRing(cfg.LogCaptureLines)
+	}
 	return ep, nil
 }
 
@@ -145,8 +163,16 @@ func (ep *EtcdServerProcess) Start() error {
 		}
 	}
 	ep.cfg.lg.Info("starting server...", zap.String("name", ep.cfg.Name))
-	proc, err := SpawnCmdWithLogger(ep.cfg.lg, append([]string{ep.cfg.ExecPath}, ep.cfg.Args...), ep.cfg.EnvVars)
+	args := ep.cfg.Args
+	if ep.logs != nil {
+		var err error
+		if args, err = ep.startLogCapture(); err != nil {
+			return err
+		}
+	}
+	proc, err := SpawnCmdWithLogger(ep.cfg.lg, append([]string{ep.cfg.ExecPath}, args...), ep.cfg.EnvVars)
 	if err != nil {
+		ep.stopLogCapture()
 		return err
 	}
 	ep.proc = proc
@@ -179,6 +205,7 @@ func (ep *EtcdServerProcess) Stop() (err error) {
 	if err != nil {
 		return err
 	}
+	ep.stopLogCapture()
 	ep.proc = nil
 	<-ep.donec
 	ep.donec = make(chan struct{})
@@ -220,6 +247,17 @@ func (ep *EtcdServerProcess) WithStopSignal(sig os.Signal) os.Signal {
 
 func (ep *EtcdServerProcess) waitReady() error {
 	defer close(ep.donec)
+	if ep.logs != nil {
+		_, err := ep.logs.expectFunc(func(l string) bool {
+			for _, s := range EtcdServerReadyLines {
+				if strings.Contains(l, s) {
+					return true
+				}
+			}
+			return false
+		})
+		return err
+	}
 	return WaitReadyExpectProc(ep.proc, EtcdServerReadyLines)
 }
 
@@ -229,9 +267,78 @@ func (ep *EtcdServerProcess) Logs() LogsExpect {
 	if ep.proc == nil {
 		ep.cfg.lg.Panic("Please grap logs before process is stopped")
 	}
+	if ep.logs != nil {
+		return ep.logs
+	}
 	return ep.proc
 }
 
+// CapturedLogs returns up to LogCaptureLines of the latest log lines of the
+// member, including the runs before it was last restarted.
+func (ep *EtcdServerProcess) CapturedLogs() []string {
+	if ep.logs == nil {
+		return nil
+	}
+	return ep.logs.lines()
+}
+
+// startLogCapture creates the FIFO the next run logs to, starts reading it
+// into the log ring and returns the member arguments pointing etcd at it.
+func (ep *EtcdServerProcess) startLogCapture() ([]string, error) {
+	dir, err := os.MkdirTemp("", "etcd-log")
+	if err != nil {
+		return nil, err
+	}
+	fifo := filepath.Join(dir, "log")
+	if err = syscall.Mkfifo(fifo, 0600); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	ep.logDir = dir
+	ep.logsDone = make(chan struct{})
+	ep.logs.startRun()
+	go ep.readLogs(fifo, ep.logsDone)
+	return append(append([]string(nil), ep.cfg.Args...), "--log-outputs", fifo), nil
+}
+
+func (ep *EtcdServerProcess) readLogs(fifo string, donec chan struct{}) {
+	defer close(donec)
+	defer ep.logs.endRun()
+	// blocks until etcd opens its log output
+	f, err := os.Open(fifo)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	for {
+		l, err := r.ReadString('\n')
+		if l != "" {
+			ep.logs.add(l)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// stopLogCapture waits until the log output of the exited run is read and
+// removes its FIFO.
+func (ep *EtcdServerProcess) stopLogCapture() {
+	if ep.logsDone == nil {
+		return
+	}
+	fifo := filepath.Join(ep.logDir, "log")
+	// release the reader if etcd exited before opening its log output; this
+	// fails once the reader is done with the FIFO
+	if w, err := os.OpenFile(fifo, os.O_WRONLY|syscall.O_NONBLOCK, 0); err == nil {
+		w.Close()
+	}
+	<-ep.logsDone
+	ep.logsDone = nil
+	os.RemoveAll(ep.logDir)
+}
+
 func (ep *EtcdServerProcess) PeerProxy() proxy.Server {
 	return ep.proxy
 }
@@ -251,6 +358,7 @@ func (ep *EtcdServerProcess) IsRunning() bool {
 
 	ep.cfg.lg.Info("server exited",
 		zap.String("name", ep.cfg.Name))
+	ep.stopLogCapture()
 	ep.proc = nil
 	return false
 }
@@ -259,6 +367,98 @@ func (ep *EtcdServerProcess) Etcdctl(connType ClientConnType, isAutoTLS, v2 bool
 	return NewEtcdctl(ep.EndpointsV3(), connType, isAutoTLS, v2)
 }
 
+// logRing keeps the latest lines added to it, dropping the oldest once full.
+// As LogsExpect it only matches and returns the lines of the current run.
+type logRing struct {
+	mu       sync.Mutex
+	buf      []string
+	total    int  // lines added so far
+	runStart int  // total when the current run started
+	runDone  bool // the output of the current run ended
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{buf: make([]string, size)}
+}
+
+func (r *logRing) startRun() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runStart = r.total
+	r.runDone = false
+}
+
+func (r *logRing) endRun() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runDone = true
+}
+
+func (r *logRing) add(l string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buf[r.total%len(r.buf)] = l
+	r.total++
+}
+
+// since returns a copy of the kept lines from the start-th line added on,
+// oldest first. r.mu must be held.
+func (r *logRing) since(start int) []string {
+	start = max(start, r.total-len(r.buf))
+	lines := make([]string, 0, r.total-start)
+	for i := start; i < r.total; i++ {
+		lines = append(lines, r.buf[i%len(r.buf)])
+	}
+	return lines
+}
+
+// lines returns a copy of the kept lines, oldest first.
+func (r *logRing) lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.since(0)
+}
+
+// expectFunc returns the first line of the current run satisfying f, waiting
+// for it until the output of the run ends.
+func (r *logRing) expectFunc(f func(string) bool) (string, error) {
+	r.mu.Lock()
+	next := r.runStart
+	r.mu.Unlock()
+	for {
+		r.mu.Lock()
+		for _, l := range r.since(next) {
+			if f(l) {
+				r.mu.Unlock()
+				return l, nil
+			}
+		}
+		next = r.total
+		done := r.runDone
+		r.mu.Unlock()
+		if done {
+			return "", errors.New("match not found, the member log output ended")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func (r *logRing) Expect(s string) (string, error) {
+	return r.expectFunc(func(l string) bool { return strings.Contains(l, s) })
+}
+
+func (r *logRing) Lines() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.since(r.runStart)
+}
+
+func (r *logRing) LineCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.total - r.runStart
+}
+
 type BinaryFailpoints struct {
 	member         EtcdProcess
 	availableCache map[string]string
@@ -0,0 +1,36 @@
+// Copyright 2023 The etcd Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package e2e
+
+import "syscall"
+
+// mountTmpfs mounts a tmpfs with the given options on dir. It fails with
+// syscall.EPERM without CAP_SYS_ADMIN.
+func mountTmpfs(dir, opts string) error {
+	return syscall.Mount("tmpfs", dir, "tmpfs", 0, opts)
+}
+
+// remountTmpfs changes the options of the tmpfs mounted on dir.
+func remountTmpfs(dir, opts string) error {
+	return syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_REMOUNT, opts)
+}
+
+// unmountTmpfs unmounts the tmpfs mounted on dir.
+func unmountTmpfs(dir string) error {
+	return syscall.Unmount(dir, 0)
+}
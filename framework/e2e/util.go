@@ -15,10 +15,18 @@
 package e2e
 
 import (
+	"bufio"
 	"context"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -79,6 +87,29 @@ func SpawnWithExpectLines(args []string, envVars map[string]string, xs ...string
 	return lines, perr
 }
 
+// SpawnWithExpectError runs the command to completion and returns nil only if
+// it exits with a non-zero status and its combined output contains expectErr.
+func SpawnWithExpectError(args []string, envVars map[string]string, expectErr string) error {
+	cmd := exec.Command(args[0], args[1:]...)
+	// variables passed as parameter take priority over the os environment
+	cmd.Env = os.Environ()
+	for k, v := range envVars {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return fmt.Errorf("%v unexpectedly succeeded (expected error %q, got output %q)", args, expectErr, out)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return fmt.Errorf("failed to run command %v with error: %w", args, err)
+	}
+	if !strings.Contains(string(out), expectErr) {
+		return fmt.Errorf("%v %v (expected error %q, got output %q)", args, err, expectErr, out)
+	}
+	return nil
+}
+
 func RunUtilCompletion(args []string, envVars map[string]string) ([]string, error) {
 	proc, err := SpawnCmd(args, envVars)
 	if err != nil {
@@ -140,6 +171,28 @@ func ToTLS(s string) string {
 	return s
 }
 
+// PatchArgs sets flag to newValue in args, given either as "--flag=value", as
+// a "--flag value" pair or as a bare boolean "--flag", and appends
+// "--flag=newValue" if flag is absent.
+func PatchArgs(args []string, flag, newValue string) []string {
+	for i, arg := range args {
+		if arg == "--"+flag {
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				args[i+1] = newValue
+			} else {
+				// a bare boolean flag, the next argument is not its value
+				args[i] = fmt.Sprintf("--%s=%s", flag, newValue)
+			}
+			return args
+		}
+		if strings.HasPrefix(arg, "--"+flag+"=") {
+			args[i] = fmt.Sprintf("--%s=%s", flag, newValue)
+			return args
+		}
+	}
+	return append(args, fmt.Sprintf("--%s=%s", flag, newValue))
+}
+
 func SkipInShortMode(t testing.TB) {
 	testutil.SkipTestIfShortMode(t, "e2e tests are not running in --short mode")
 }
@@ -163,3 +216,125 @@ func ExecuteUntil(ctx context.Context, t *testing.T, f func()) {
 	case <-donec:
 	}
 }
+
+// SnapshotFiles returns the snapshot files in the given member data dir.
+func SnapshotFiles(dataDir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dataDir, "member", "snap", "*.snap"))
+}
+
+// CorruptSnapshotFiles flips the last byte of every snapshot file in the given
+// member data dir, which lies in the snapshot payload covered by its CRC.
+func CorruptSnapshotFiles(dataDir string) error {
+	snaps, err := SnapshotFiles(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(snaps) == 0 {
+		return fmt.Errorf("no snapshot files found in %q", dataDir)
+	}
+	for _, snap := range snaps {
+		data, err := os.ReadFile(snap)
+		if err != nil {
+			return err
+		}
+		data[len(data)-1] ^= 0xff
+		if err = os.WriteFile(snap, data, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WALFiles returns the WAL files in the given member data dir.
+func WALFiles(dataDir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dataDir, "member", "wal", "*.wal"))
+}
+
+// StepClock steps the wall clock of the host by d, which may be negative. The
+// clock is shared by every process on the host, not only the members of a
+// cluster, and stays stepped if the caller dies before stepping it back.
+// Stepping it requires the CAP_SYS_TIME capability, see HasCapSysTime, and
+// tests calling it should also require an explicit opt-in.
+func StepClock(d time.Duration) error {
+	t := time.Now().Add(d)
+	if out, err := exec.Command("date", "-s", fmt.Sprintf("@%d.%09d", t.Unix(), t.Nanosecond())).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to step the clock by %v (%v): %s", d, err, out)
+	}
+	return nil
+}
+
+// HasCapSysTime reports whether the test process has the CAP_SYS_TIME
+// capability in its effective set.
+func HasCapSysTime() bool {
+	const capSysTime = 25
+	status, err := os.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if capEff, ok := strings.CutPrefix(line, "CapEff:"); ok {
+			caps, err := strconv.ParseUint(strings.TrimSpace(capEff), 16, 64)
+			return err == nil && caps&(1<<capSysTime) != 0
+		}
+	}
+	return false
+}
+
+// TruncateWALTail removes the last n bytes of the records written to the
+// newest WAL segment in the given member data dir, simulating a crash in the
+// middle of a write. WAL segments are preallocated, so the zero filled space
+// after the last record is dropped as well.
+func TruncateWALTail(dataDir string, n int) error {
+	wals, err := WALFiles(dataDir)
+	if err != nil {
+		return err
+	}
+	if len(wals) == 0 {
+		return fmt.Errorf("no WAL files found in %q", dataDir)
+	}
+	f, err := os.OpenFile(wals[len(wals)-1], os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	end, err := walRecordsEnd(f)
+	if err != nil {
+		return err
+	}
+	if int64(n) > end {
+		return fmt.Errorf("cannot truncate %d bytes from %q, only %d bytes of records written", n, f.Name(), end)
+	}
+	return f.Truncate(end - int64(n))
+}
+
+// walRecordsEnd returns the offset right after the last record framed in a
+// WAL segment. Each record is prefixed with its little endian length, whose
+// top byte holds the padding of the record when the high bit is set.
+func walRecordsEnd(r io.Reader) (int64, error) {
+	br := bufio.NewReader(r)
+	var off int64
+	for {
+		var lenField int64
+		if err := binary.Read(br, binary.LittleEndian, &lenField); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return off, nil
+			}
+			return 0, err
+		}
+		if lenField == 0 {
+			return off, nil
+		}
+		recBytes := int64(uint64(lenField) & ^(uint64(0xff) << 56))
+		if lenField < 0 {
+			recBytes += int64((uint64(lenField) >> 56) & 0x7)
+		}
+		if _, err := br.Discard(int(recBytes)); err != nil {
+			if err == io.EOF {
+				return off, nil
+			}
+			return 0, err
+		}
+		off += 8 + recBytes
+	}
+}